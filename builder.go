@@ -0,0 +1,150 @@
+//
+// build matrix/TRC display profiles
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProfileBuilder describes a matrix/TRC display profile to be built.
+//
+// RGB profiles are defined by chromaticities of the primaries and the white point,
+// and gray profiles by the white point only.
+// The colorants are adapted to the PCS white by the Bradford transform.
+type ProfileBuilder struct {
+	Version    int             // major version of the profile, 2 or 4; 4 if zero
+	ColorSpace string          // ColorSpaceRGB or ColorSpaceGray; RGB if empty
+	Primaries  [3]Chromaticity // red, green and blue primaries of RGB profiles
+	White      Chromaticity    // white point; D50 if zero
+
+	// tone curves; one curve for all channels or a curve for each channel.
+	// GammaCurve(1) is used if empty.
+	// v2 profiles have no parametric curves, so parametric curves are sampled.
+	Curves []Curve
+
+	Description string
+	Copyright   string          // the required copyright tag is written even if empty
+	Created     time.Time       // creation date; no date if zero
+	Intent      RenderingIntent // default rendering intent
+}
+
+// Build serializes the profile.
+// v4 profiles have the MD5 profile ID computed.
+func (pb *ProfileBuilder) Build() (iccProfile []byte, err error) {
+	version := pb.Version
+	if version == 0 {
+		version = 4
+	}
+	if version != 2 && version != 4 {
+		err = fmt.Errorf("unsupported profile version %d", version)
+		return
+	}
+	v4 := version == 4
+	colorSpace := pb.ColorSpace
+	if colorSpace == "" {
+		colorSpace = ColorSpaceRGB
+	}
+	channels := 3
+	switch colorSpace {
+	case ColorSpaceRGB:
+	case ColorSpaceGray:
+		channels = 1
+	default:
+		err = fmt.Errorf("unsupported color space %q", colorSpace)
+		return
+	}
+	if pb.Intent < IntentPerceptual || pb.Intent > IntentAbsoluteColorimetric {
+		err = fmt.Errorf("unknown rendering intent %d", int(pb.Intent))
+		return
+	}
+	white := pcsWhite
+	if pb.White != (Chromaticity{}) {
+		if pb.White.Y <= 0 {
+			err = fmt.Errorf("invalid white point")
+			return
+		}
+		white = pb.White.XYZ()
+	}
+	curves := pb.Curves
+	switch len(curves) {
+	case 0:
+		curves = []Curve{GammaCurve(1)}
+	case 1, channels:
+	default:
+		err = fmt.Errorf("%d curves for %d channels", len(curves), channels)
+		return
+	}
+	trc := make([][]byte, channels)
+	for i := range trc {
+		c := curves[0]
+		if len(curves) == channels {
+			c = curves[i]
+		}
+		trc[i], err = encodeCurve(c, v4)
+		if err != nil {
+			return
+		}
+	}
+
+	h := &ProfileHeader{
+		DeviceClass:     ClassDisplay,
+		ColorSpace:      colorSpace,
+		PCS:             ColorSpaceXYZ,
+		Created:         pb.Created,
+		RenderingIntent: pb.Intent,
+		Illuminant:      pcsWhite,
+	}
+	var tags []profileTagData
+	if v4 {
+		h.Version = ProfileVersion{4, 3, 0}
+		tags = append(tags, profileTagData{TagDescription, encodeMLUC([]LocalizedText{{"en", "US", pb.Description}})})
+		tags = append(tags, profileTagData{TagCopyright, encodeMLUC([]LocalizedText{{"en", "US", pb.Copyright}})})
+		// v4 display profiles have the PCS white as the media white, with the adaptation in 'chad'
+		tags = append(tags, profileTagData{TagMediaWhitePoint, encodeXYZ(pcsWhite)})
+	} else {
+		h.Version = ProfileVersion{2, 1, 0}
+		tags = append(tags, profileTagData{TagDescription, encodeTextDescription(pb.Description)})
+		tags = append(tags, profileTagData{TagCopyright, encodeText(pb.Copyright)})
+		tags = append(tags, profileTagData{TagMediaWhitePoint, encodeXYZ(white)})
+	}
+
+	if colorSpace == ColorSpaceRGB {
+		var c [3]XYZ
+		c, err = rgbColorants(pb.Primaries, white)
+		if err != nil {
+			return
+		}
+		tags = append(tags,
+			profileTagData{TagRedColorant, encodeXYZ(c[0])},
+			profileTagData{TagGreenColorant, encodeXYZ(c[1])},
+			profileTagData{TagBlueColorant, encodeXYZ(c[2])},
+			profileTagData{TagRedTRC, trc[0]},
+			profileTagData{TagGreenTRC, trc[1]},
+			profileTagData{TagBlueTRC, trc[2]},
+		)
+	} else {
+		tags = append(tags, profileTagData{TagGrayTRC, trc[0]})
+	}
+
+	if v4 {
+		var m matrix3
+		m, err = bradfordAdaptation(white, pcsWhite)
+		if err != nil {
+			return
+		}
+		tags = append(tags, profileTagData{TagChromaticAdapt, encodeS15f16Array(m[:])})
+	}
+
+	iccProfile, err = encodeProfile(h, tags)
+	if err != nil {
+		return
+	}
+	if v4 {
+		id := profileID(iccProfile)
+		copy(iccProfile[84:], id[:])
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package imageicc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProfileBuilder(t *testing.T) {
+	pb := &ProfileBuilder{
+		Primaries:   [3]Chromaticity{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}},
+		White:       Chromaticity{0.3127, 0.3290},
+		Curves:      []Curve{GammaCurve(2.2), GammaCurve(1.8), srgbCurve},
+		Description: "Measured Display",
+		Copyright:   "Test",
+	}
+	b, err := pb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := ParseProfile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.Version.Major != 4 || p.Header.ColorSpace != ColorSpaceRGB || p.Header.DeviceClass != ClassDisplay {
+		t.Errorf("wrong header: %+v", p.Header)
+	}
+	if p.VerifyID() != ProfileIDValid {
+		t.Errorf("wrong profile ID %x", p.Header.ProfileID)
+	}
+	if d, _ := p.Description(); d != "Measured Display" {
+		t.Errorf("description %q", d)
+	}
+	curves, err := p.ToneCurves()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{math.Pow(0.5, 2.2), math.Pow(0.5, 1.8), srgbCurve.Eval(0.5)} {
+		if got := curves[i].Eval(0.5); math.Abs(got-want) > 1e-4 {
+			t.Errorf("curve %d: %g, want %g", i, got, want)
+		}
+	}
+	// sRGB primaries and white give the sRGB colorants
+	std, _ := StandardICC(ProfileSRGB, 4)
+	sp, _ := ParseProfile(std)
+	r1, g1, b1, _ := p.Colorants()
+	r2, g2, b2, _ := sp.Colorants()
+	if r1 != r2 || g1 != g2 || b1 != b2 {
+		t.Errorf("colorants differ from sRGB")
+	}
+
+	// v2, gray, sampled curve
+	pb = &ProfileBuilder{Version: 2, ColorSpace: ColorSpaceGray, Curves: []Curve{SampledCurve{0, 0.2, 1}}}
+	b, err = pb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = ParseProfile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Header.ProfileID != [16]byte{} || p.HasTag(TagChromaticAdapt) {
+		t.Errorf("v2 profile has v4 fields")
+	}
+	// the required copyright tag is present even if empty
+	if c, err := p.Copyright(); err != nil || c != "" || !p.HasTag(TagCopyright) {
+		t.Errorf("empty copyright: %q, %v", c, err)
+	}
+	if w, _ := p.MediaWhitePoint(); math.Abs(w.X-0.9642) > 1e-4 || math.Abs(w.Z-0.8249) > 1e-4 {
+		t.Errorf("default white point %v", w)
+	}
+	if c, err := p.TagCurve(TagGrayTRC); err != nil || math.Abs(c.Eval(0.5)-0.2) > 1e-4 {
+		t.Errorf("gray curve: %v", err)
+	}
+
+	// errors
+	for i, pb := range []*ProfileBuilder{
+		{Version: 3},
+		{ColorSpace: ColorSpaceCMYK},
+		{Curves: []Curve{GammaCurve(1), GammaCurve(1)}},
+		{Primaries: [3]Chromaticity{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0}}},
+		{ColorSpace: ColorSpaceGray, White: Chromaticity{0.3, 0}},
+	} {
+		if _, err := pb.Build(); err == nil {
+			t.Errorf("case %d: invalid profile built", i)
+		}
+	}
+}
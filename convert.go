@@ -0,0 +1,160 @@
+//
+// decode an image and convert its pixels to sRGB using the embedded ICC profile
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // register GIF decoder
+	_ "image/jpeg" // register JPEG decoder
+	_ "image/png"  // register PNG decoder
+	"io"
+)
+
+// build a PCS stage of sRGB
+func newSRGBStage() (*pcsStage, error) {
+	def := standardProfiles[ProfileSRGB]
+	colorants, err := rgbColorants(def.primaries, def.white.XYZ())
+	if err != nil {
+		return nil, err
+	}
+	return newRGBMatrixStage(colorants, []Curve{def.curve, def.curve, def.curve})
+}
+
+// DecodeSRGB decodes an image and converts it to sRGB using the embedded ICC profile.
+// Images without a profile are assumed to be sRGB already,
+// as are images of formats registered to the image package but unknown to LoadICC.
+//
+// JPEG, PNG and GIF decoders are registered by this package;
+// decoders of other formats, e.g. TIFF, must be registered by the caller.
+func DecodeSRGB(in io.ReadSeeker) (img *image.NRGBA, err error) {
+	return DecodeSRGBWithOptions(in, nil)
+}
+
+// DecodeSRGBWithOptions is DecodeSRGB with transform options.
+func DecodeSRGBWithOptions(in io.ReadSeeker, opts *TransformOptions) (img *image.NRGBA, err error) {
+	start, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	iccProfile, format, err := LoadICC(in)
+	if err != nil {
+		if format != FormatUnknown {
+			return
+		}
+		// let image.Decode try the format
+		iccProfile, err = nil, nil
+	}
+	_, err = in.Seek(start, io.SeekStart)
+	if err != nil {
+		return
+	}
+	src, _, err := image.Decode(in)
+	if err != nil {
+		return
+	}
+	return ConvertToSRGB(src, iccProfile, opts)
+}
+
+// maximum number of converted colors cached by ConvertToSRGB
+const maxColorCache = 1 << 16
+
+// ConvertToSRGB converts a decoded image to sRGB using an ICC profile.
+// If iccProfile is empty then the image is copied as is.
+// If opts is nil then the default rendering intent of the profile is used.
+//
+// Gray profiles take the luminance of pixels, RGB profiles take the RGB values,
+// and CMYK profiles require an *image.CMYK. Alpha is preserved.
+func ConvertToSRGB(src image.Image, iccProfile []byte, opts *TransformOptions) (img *image.NRGBA, err error) {
+	b := src.Bounds()
+	img = image.NewNRGBA(b)
+	if len(iccProfile) == 0 {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				img.Set(x, y, src.At(x, y))
+			}
+		}
+		return
+	}
+
+	p, err := ParseProfile(iccProfile)
+	if err != nil {
+		return nil, err
+	}
+	opts, intent, err := resolveIntent(p, opts)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newPCSStage(p, intent, opts)
+	if err != nil {
+		return nil, err
+	}
+	d, err := newSRGBStage()
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTransform(s, d, intent, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cmyk, isCMYK := src.(*image.CMYK)
+	switch {
+	case t.InputChannels() != 1 && t.InputChannels() != 3 && t.InputChannels() != 4:
+		return nil, fmt.Errorf("unsupported color space %q", p.Header.ColorSpace)
+	case t.InputChannels() == 4 && !isCMYK:
+		return nil, fmt.Errorf("%s profile requires a CMYK image", p.Header.ColorSpace)
+	case t.InputChannels() != 4 && isCMYK:
+		return nil, fmt.Errorf("%s profile does not match a CMYK image", p.Header.ColorSpace)
+	}
+
+	// images usually have far fewer distinct colors than pixels;
+	// the cache is cleared when full to bound its memory
+	cache := make(map[color.NRGBA64]color.NRGBA)
+	in := make([]float64, t.InputChannels())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var key color.NRGBA64
+			if isCMYK {
+				c := cmyk.CMYKAt(x, y)
+				key = color.NRGBA64{uint16(c.C) * 0x101, uint16(c.M) * 0x101, uint16(c.Y) * 0x101, uint16(c.K) * 0x101}
+			} else {
+				key = color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+			}
+			if c, ok := cache[key]; ok {
+				img.SetNRGBA(x, y, c)
+				continue
+			}
+
+			alpha := uint8(key.A >> 8)
+			switch {
+			case isCMYK:
+				for i, v := range []uint16{key.R, key.G, key.B, key.A} {
+					in[i] = float64(v) / 65535
+				}
+				alpha = 0xff
+			case len(in) == 1:
+				g := color.Gray16Model.Convert(color.NRGBA64{key.R, key.G, key.B, 0xffff}).(color.Gray16)
+				in[0] = float64(g.Y) / 65535
+			default:
+				in[0], in[1], in[2] = float64(key.R)/65535, float64(key.G)/65535, float64(key.B)/65535
+			}
+			out := t.Convert(in)
+			c := color.NRGBA{to8bit(out[0]), to8bit(out[1]), to8bit(out[2]), alpha}
+			if len(cache) >= maxColorCache {
+				cache = make(map[color.NRGBA64]color.NRGBA)
+			}
+			cache[key] = c
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return
+}
+
+// convert a normalized value to 8 bits
+func to8bit(v float64) uint8 {
+	return uint8(clamp01(v)*255 + 0.5)
+}
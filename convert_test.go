@@ -0,0 +1,118 @@
+package imageicc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	imgpng "image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConvertToSRGB(t *testing.T) {
+	srgb := testRGBProfile(t, testSRGBColorants, testParaTag(3, testSRGBParams...))
+	p3 := testRGBProfile(t, testP3Colorants, testParaTag(3, testSRGBParams...))
+	gray := testGrayProfile(t, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x01\x00"))
+
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 100, 50, 0xff})
+	src.SetNRGBA(1, 0, color.NRGBA{0, 255, 0, 0xff})
+	src.SetNRGBA(2, 0, color.NRGBA{128, 128, 128, 0x40})
+	src.SetNRGBA(3, 0, color.NRGBA{200, 100, 50, 0xff})
+	var enc bytes.Buffer
+	err := imgpng.Encode(&enc, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no profile; pixels are copied
+	img, err := DecodeSRGB(bytes.NewReader(enc.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.Pix, src.Pix) {
+		t.Errorf("image without profile changed: %v", img.Pix)
+	}
+
+	// a format unknown to LoadICC but decoded by the image package is taken as sRGB
+	image.RegisterFormat("imageicc-test", "IMAGEICC-TEST", func(io.Reader) (image.Image, error) {
+		return src, nil
+	}, func(io.Reader) (image.Config, error) {
+		return image.Config{ColorModel: src.ColorModel(), Width: 4, Height: 1}, nil
+	})
+	img, err = DecodeSRGB(strings.NewReader("IMAGEICC-TEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.Pix, src.Pix) {
+		t.Errorf("image of an unknown format changed: %v", img.Pix)
+	}
+	_, err = DecodeSRGB(strings.NewReader("not an image"))
+	if err == nil {
+		t.Errorf("unknown data decoded")
+	}
+
+	// Display P3
+	var tagged bytes.Buffer
+	err = EmbedICCtoPNG(&tagged, bytes.NewReader(enc.Bytes()), p3.Bytes(), "P3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = DecodeSRGB(bytes.NewReader(tagged.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTransform(p3, srgb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 4; x++ {
+		c := src.NRGBAAt(x, 0)
+		want := tr.Convert([]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255})
+		got := img.NRGBAAt(x, 0)
+		if !nearColor([]float64{float64(got.R) / 255, float64(got.G) / 255, float64(got.B) / 255}, want, 1.0/255) {
+			t.Errorf("pixel %d: %v, want %v", x, got, want)
+		}
+		if got.A != c.A {
+			t.Errorf("pixel %d: alpha %d, want %d", x, got.A, c.A)
+		}
+	}
+	if g := img.NRGBAAt(1, 0); g.R != 0 || g.G != 255 {
+		t.Errorf("P3 green not clipped: %v", g)
+	}
+
+	// gray gamma 1.0; luminance goes through the sRGB curve
+	gimg := image.NewGray(image.Rect(0, 0, 1, 1))
+	gimg.SetGray(0, 0, color.Gray{0x33})
+	img, err = ConvertToSRGB(gimg, gray.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := img.NRGBAAt(0, 0); g.R != g.G || g.G != g.B || g.R < 0x7b || g.R > 0x7d {
+		t.Errorf("gray conversion: %v", g)
+	}
+
+	// a CMYK profile requires a CMYK image
+	cmyk := testCMYKProfile(t, testTag{"A2B0", testCMYKLut(100, 0)})
+	_, err = ConvertToSRGB(src, cmyk.Bytes(), nil)
+	if err == nil {
+		t.Errorf("CMYK profile accepted for an RGB image")
+	}
+	// and a CMYK image requires a CMYK profile
+	cimg := image.NewCMYK(image.Rect(0, 0, 1, 1))
+	cimg.SetCMYK(0, 0, color.CMYK{10, 20, 30, 40})
+	for _, p := range []*Profile{srgb, gray} {
+		_, err = ConvertToSRGB(cimg, p.Bytes(), nil)
+		if err == nil {
+			t.Errorf("%s profile accepted for a CMYK image", p.Header.ColorSpace)
+		}
+	}
+	img, err = ConvertToSRGB(cimg, cmyk.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := img.NRGBAAt(0, 0); g.A != 0xff {
+		t.Errorf("CMYK conversion: %v", g)
+	}
+}
@@ -0,0 +1,158 @@
+//
+// detect image container format and dispatch to a matching ICC loader or writer
+//
+
+package imageicc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Format is an image container format recognized by this package.
+type Format int
+
+const (
+	FormatUnknown Format = iota // unrecognized format
+	FormatJPG                   // JPEG/JFIF
+	FormatPNG                   // PNG
+	FormatGIF                   // GIF87a/GIF89a
+	FormatTIFF                  // TIFF, either byte order
+	FormatWebP                  // WebP, RIFF container
+	FormatHEIF                  // HEIF, including HEIC and AVIF
+	FormatJP2                   // JPEG 2000, JP2/JPX file or bare codestream
+	FormatJXL                   // JPEG XL, container or bare codestream
+	FormatPSD                   // Photoshop PSD or PSB
+)
+
+var formatName = map[Format]string{
+	FormatUnknown: "unknown",
+	FormatJPG:     "jpeg",
+	FormatPNG:     "png",
+	FormatGIF:     "gif",
+	FormatTIFF:    "tiff",
+	FormatWebP:    "webp",
+	FormatHEIF:    "heif",
+	FormatJP2:     "jpeg2000",
+	FormatJXL:     "jxl",
+	FormatPSD:     "psd",
+}
+
+func (f Format) String() string {
+	if s, ok := formatName[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// size of the leading bytes needed to sniff a format
+const sniffLen = 16
+
+// detect format from the leading bytes of a file
+func sniffFormat(h []byte) Format {
+	switch {
+	case len(h) >= 2 && h[0] == 0xff && h[1] == markerSOI: // 0xff 0xd8: jpeg SOI
+		return FormatJPG
+	case bytes.HasPrefix(h, pngHeader):
+		return FormatPNG
+	case len(h) >= 6 && string(h[:4]) == "GIF8" && (h[4] == '7' || h[4] == '9') && h[5] == 'a':
+		return FormatGIF
+	case bytes.HasPrefix(h, []byte("II\x2a\x00")), bytes.HasPrefix(h, []byte("MM\x00\x2a")):
+		return FormatTIFF
+	case len(h) >= 12 && string(h[:4]) == "RIFF" && string(h[8:12]) == "WEBP":
+		return FormatWebP
+	case len(h) >= 12 && string(h[4:8]) == "ftyp" && heifBrands[string(h[8:12])]:
+		return FormatHEIF
+	case bytes.HasPrefix(h, jp2Signature), bytes.HasPrefix(h, j2kCodestream):
+		return FormatJP2
+	case bytes.HasPrefix(h, jxlSignature), bytes.HasPrefix(h, jxlCodestream):
+		return FormatJXL
+	case len(h) >= 6 && bytes.HasPrefix(h, psdSignature) && h[4] == 0 && (h[5] == 1 || h[5] == 2):
+		return FormatPSD
+	}
+	return FormatUnknown
+}
+
+// DetectFormat reads the magic bytes of an image and returns its container format.
+// The read position of the stream is restored before return.
+func DetectFormat(in io.ReadSeeker) (format Format, err error) {
+	offset, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	h := make([]byte, sniffLen)
+	n, err := io.ReadFull(in, h)
+	if err == io.ErrUnexpectedEOF || err == io.EOF { // short files are fine
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	_, err = in.Seek(offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+	return sniffFormat(h[:n]), nil
+}
+
+// Read ICC profile embedded in an image of any supported format.
+// The container format is detected from the magic bytes of the stream.
+// If there is no ICC profile then nil data and no error is returned.
+func LoadICC(in io.ReadSeeker) (iccProfile []byte, format Format, err error) {
+	format, err = DetectFormat(in)
+	if err != nil {
+		return
+	}
+	switch format {
+	case FormatJPG:
+		iccProfile, err = LoadICCfromJPG(in)
+	case FormatPNG:
+		iccProfile, err = LoadICCfromPNG(in)
+	case FormatGIF:
+		iccProfile, err = LoadICCfromGIF(in)
+	case FormatTIFF:
+		iccProfile, err = LoadICCfromTIFF(in)
+	case FormatWebP:
+		iccProfile, err = LoadICCfromWebP(in)
+	case FormatHEIF:
+		iccProfile, err = LoadICCfromHEIF(in)
+	case FormatJP2:
+		iccProfile, err = LoadICCfromJP2(in)
+	case FormatJXL:
+		iccProfile, err = LoadICCfromJXL(in)
+	case FormatPSD:
+		iccProfile, err = LoadICCfromPSD(in)
+	default:
+		err = fmt.Errorf("unknown image format")
+	}
+	return
+}
+
+// Copy an image of any supported format from in to out, removing embedded ICC profiles.
+// Pixel data is copied as is without re-encoding.
+func StripICC(out io.Writer, in io.ReadSeeker) (format Format, err error) {
+	format, err = DetectFormat(in)
+	if err != nil {
+		return
+	}
+	switch format {
+	case FormatJPG:
+		err = StripICCfromJPG(out, in)
+	case FormatPNG:
+		err = StripICCfromPNG(out, in)
+	case FormatGIF:
+		err = StripICCfromGIF(out, in)
+	case FormatTIFF:
+		err = StripICCfromTIFF(out, in)
+	case FormatWebP:
+		err = StripICCfromWebP(out, in)
+	case FormatPSD:
+		err = StripICCfromPSD(out, in)
+	case FormatHEIF, FormatJP2, FormatJXL:
+		err = fmt.Errorf("removing ICC profile from %v is not supported", format)
+	default:
+		err = fmt.Errorf("unknown image format")
+	}
+	return
+}
@@ -0,0 +1,153 @@
+package imageicc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	imgpng "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		head   []byte
+		format Format
+	}{
+		{[]byte{0xff, 0xd8, 0xff, 0xe0}, FormatJPG},
+		{pngHeader, FormatPNG},
+		{[]byte("GIF89a"), FormatGIF},
+		{[]byte("GIF87a"), FormatGIF},
+		{[]byte("II\x2a\x00\x08\x00\x00\x00"), FormatTIFF},
+		{[]byte("MM\x00\x2a\x00\x00\x00\x08"), FormatTIFF},
+		{[]byte("RIFF\x24\x00\x00\x00WEBPVP8 "), FormatWebP},
+		{[]byte("RIFF\x24\x00\x00\x00WAVEfmt "), FormatUnknown},
+		{[]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), FormatHEIF},
+		{[]byte("\x00\x00\x00\x18ftypavif\x00\x00\x00\x00"), FormatHEIF},
+		{[]byte("\x00\x00\x00\x18ftypisom\x00\x00\x00\x00"), FormatUnknown},
+		{[]byte("\x00\x00\x00\x0cjP  \r\n\x87\n\x00\x00\x00\x14"), FormatJP2},
+		{[]byte{0xff, 0x4f, 0xff, 0x51}, FormatJP2},
+		{[]byte("\x00\x00\x00\x0cJXL \r\n\x87\n"), FormatJXL},
+		{[]byte{0xff, 0x0a, 0xfa}, FormatJXL},
+		{[]byte("8BPS\x00\x01"), FormatPSD},
+		{[]byte("8BPS\x00\x02"), FormatPSD},
+		{[]byte("8BPS\x00\x03"), FormatUnknown},
+		{[]byte("BM"), FormatUnknown},
+		{nil, FormatUnknown},
+	}
+	for _, tc := range tests {
+		r := bytes.NewReader(tc.head)
+		f, err := DetectFormat(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != tc.format {
+			t.Errorf("format mismatch for % x: got %v, want %v", tc.head, f, tc.format)
+		}
+		if pos, _ := r.Seek(0, io.SeekCurrent); pos != 0 {
+			t.Errorf("read position not restored")
+		}
+	}
+}
+
+// a dummy profile of a given size
+func testProfile(sz int, seed byte) []byte {
+	b := make([]byte, sz)
+	for i := range b {
+		b[i] = byte(i*7) + seed
+	}
+	return b
+}
+
+// read a sample file of _testdata
+func testSample(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("_testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// a small test image
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 16), uint8(y * 32), 0x80, 0xff})
+		}
+	}
+	return img
+}
+
+func TestStripICC(t *testing.T) {
+	icc := testProfile(2000, 1)
+	img := testImage()
+
+	var jpgSrc, pngSrc, gifSrc bytes.Buffer
+	jpeg.Encode(&jpgSrc, img, nil)
+	imgpng.Encode(&pngSrc, img)
+	gif.Encode(&gifSrc, img, nil)
+
+	var jpgICC, pngICC, gifICC, tifICC, webpICC, psdICC bytes.Buffer
+	if err := EmbedICCtoJPG(&jpgICC, bytes.NewReader(jpgSrc.Bytes()), icc); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedICCtoPNG(&pngICC, bytes.NewReader(pngSrc.Bytes()), icc, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedICCtoGIF(&gifICC, bytes.NewReader(gifSrc.Bytes()), icc); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedICCtoTIFF(&tifICC, bytes.NewReader(testTIFF(bst.BigEndian)), icc, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedICCtoWebP(&webpICC, bytes.NewReader(testWebP(false)), icc); err != nil {
+		t.Fatal(err)
+	}
+	if err := EmbedICCtoPSD(&psdICC, bytes.NewReader(testPSD(1)), icc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format Format
+		file   []byte
+		orig   []byte
+	}{
+		{FormatJPG, jpgICC.Bytes(), jpgSrc.Bytes()},
+		{FormatPNG, pngICC.Bytes(), pngSrc.Bytes()},
+		{FormatGIF, gifICC.Bytes(), gifSrc.Bytes()},
+		{FormatTIFF, tifICC.Bytes(), nil}, // the relocated IFD stays in a new place
+		{FormatWebP, webpICC.Bytes(), nil},
+		{FormatPSD, psdICC.Bytes(), nil}, // the ICC untagged flag is gone
+	}
+	for _, tc := range tests {
+		var out bytes.Buffer
+		f, err := StripICC(&out, bytes.NewReader(tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f != tc.format {
+			t.Errorf("format mismatch: got %v, want %v", f, tc.format)
+		}
+		if tc.orig != nil && !bytes.Equal(out.Bytes(), tc.orig) {
+			t.Errorf("%v: stripped file differs from the original", f)
+		}
+		loaded, _, err := LoadICC(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded != nil {
+			t.Errorf("%v: profile not removed", f)
+		}
+		if out.Len() >= len(tc.file) || bytes.Contains(out.Bytes(), icc[128:]) {
+			t.Errorf("%v: profile data left in the file", f)
+		}
+	}
+}
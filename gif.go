@@ -1,5 +1,5 @@
 //
-// read embedded ICC profile from a gif file
+// read and write embedded ICC profile in a gif file
 //
 // GIF spec
 // https://www.w3.org/Graphics/GIF/spec-gif89a.txt
@@ -231,3 +231,204 @@ func LoadICCfromGIF(in io.ReadSeeker) (iccProfile []byte, err error) {
 	// end of file
 	return
 }
+
+// copy data sub-blocks until the block terminator
+func copyGIFBlocks(w io.Writer, r io.Reader) (err error) {
+	buf := make([]byte, 256)
+	for {
+		_, err = io.ReadFull(r, buf[:1])
+		if err != nil {
+			return
+		}
+		sz := int(buf[0])
+		_, err = io.ReadFull(r, buf[1:1+sz])
+		if err != nil {
+			return
+		}
+		_, err = w.Write(buf[:1+sz])
+		if err != nil {
+			return
+		}
+		if sz == 0 { // block terminator
+			return
+		}
+	}
+}
+
+// write data as a sequence of sub-blocks followed by the block terminator
+func writeGIFBlocks(w io.Writer, data []byte) (err error) {
+	for len(data) > 0 {
+		sz := len(data)
+		if sz > 0xff {
+			sz = 0xff
+		}
+		_, err = w.Write([]byte{byte(sz)})
+		if err != nil {
+			return
+		}
+		_, err = w.Write(data[:sz])
+		if err != nil {
+			return
+		}
+		data = data[sz:]
+	}
+	_, err = w.Write([]byte{0})
+	return
+}
+
+// copy a GIF stream, removing existing ICC profile extensions
+// and inserting a new profile if iccProfile is not nil.
+func rewriteGIFICC(out io.Writer, in io.Reader, iccProfile []byte) (err error) {
+
+	// read GIF header and the logical screen descriptor
+	var gifHeader struct {
+		Version          string `binary:"[6]byte"`
+		Width, Height    int    `binary:"uint16"`
+		Flag             byte
+		BGColorIndex     byte
+		PixelAspectRatio byte
+	}
+	_, err = bst.Read(in, bst.LittleEndian, &gifHeader)
+	if err != nil {
+		return
+	}
+	if gifHeader.Version[:3] != "GIF" {
+		err = fmt.Errorf("invalid GIF header")
+		return
+	}
+	if iccProfile != nil {
+		// extensions are not allowed in GIF87a
+		gifHeader.Version = "GIF89a"
+	}
+	_, err = bst.Write(out, bst.LittleEndian, &gifHeader)
+	if err != nil {
+		return
+	}
+
+	// copy the global color table
+	if (gifHeader.Flag & 0x80) != 0 {
+		szGlobalColorTable := 1 << (1 + gifHeader.Flag&0x7)
+		_, err = io.CopyN(out, in, int64(szGlobalColorTable*3))
+		if err != nil {
+			return
+		}
+	}
+
+	// the ICC profile extension goes right after the global color table
+	if iccProfile != nil {
+		_, err = out.Write([]byte{0x21, gifextApplication, 8 + 3})
+		if err != nil {
+			return
+		}
+		_, err = io.WriteString(out, "ICCRGBG1012")
+		if err != nil {
+			return
+		}
+		err = writeGIFBlocks(out, iccProfile)
+		if err != nil {
+			return
+		}
+	}
+
+	buf := make([]byte, 16)
+	for {
+		_, err = io.ReadFull(in, buf[:1])
+		if err != nil {
+			return
+		}
+		c := buf[0]
+
+		switch c {
+		case 0x3b: // 0x3b: GIF trailer
+			_, err = out.Write(buf[:1])
+			return
+
+		case 0x2c: // 0x2c: Image descriptor
+			_, err = io.ReadFull(in, buf[1:10])
+			if err != nil {
+				return
+			}
+			_, err = out.Write(buf[:10])
+			if err != nil {
+				return
+			}
+			flag := buf[9]
+			if (flag & 0x80) != 0 { // local color table
+				szLocalColorTable := 1 << (1 + flag&0x7)
+				_, err = io.CopyN(out, in, int64(szLocalColorTable*3))
+				if err != nil {
+					return
+				}
+			}
+			// LZW minimum code size and the image data
+			_, err = io.CopyN(out, in, 1)
+			if err != nil {
+				return
+			}
+			err = copyGIFBlocks(out, in)
+			if err != nil {
+				return
+			}
+
+		case 0x21: // 0x21: extension block
+			_, err = io.ReadFull(in, buf[1:2])
+			if err != nil {
+				return
+			}
+			if buf[1] == gifextApplication {
+				// read the application identifier block
+				_, err = io.ReadFull(in, buf[2:3])
+				if err != nil {
+					return
+				}
+				sz := int(buf[2])
+				if sz != 8+3 { // ID + Auth
+					err = fmt.Errorf("application extension block header size mismatch")
+					return
+				}
+				_, err = io.ReadFull(in, buf[3:3+sz])
+				if err != nil {
+					return
+				}
+				if string(buf[3:3+sz]) == "ICCRGBG1012" {
+					// drop the existing ICC profile
+					err = copyGIFBlocks(io.Discard, in)
+					if err != nil {
+						return
+					}
+					continue
+				}
+				_, err = out.Write(buf[:3+sz])
+			} else {
+				_, err = out.Write(buf[:2])
+			}
+			if err != nil {
+				return
+			}
+			err = copyGIFBlocks(out, in)
+			if err != nil {
+				return
+			}
+
+		default:
+			err = fmt.Errorf("unknown chunk type %x", c)
+			return
+		}
+	}
+}
+
+// Copy a GIF file from in to out, embedding an ICC profile.
+// The profile is stored in an ICCRGBG1 application extension
+// placed after the global color table. Any existing one is replaced.
+func EmbedICCtoGIF(out io.Writer, in io.Reader, iccProfile []byte) (err error) {
+	if len(iccProfile) == 0 {
+		err = fmt.Errorf("empty icc profile")
+		return
+	}
+	return rewriteGIFICC(out, in, iccProfile)
+}
+
+// Copy a GIF file from in to out, removing the embedded ICC profile.
+func StripICCfromGIF(out io.Writer, in io.Reader) (err error) {
+	return rewriteGIFICC(out, in, nil)
+}
@@ -0,0 +1,71 @@
+package imageicc
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+)
+
+func TestEmbedICCtoGIF(t *testing.T) {
+	var src bytes.Buffer
+	err := gif.Encode(&src, testImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	icc := testProfile(1000, 1)
+	var dst bytes.Buffer
+	err = EmbedICCtoGIF(&dst, bytes.NewReader(src.Bytes()), icc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadICCfromGIF(bytes.NewReader(dst.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc) {
+		t.Fatalf("embedded profile mismatch")
+	}
+
+	// replace the profile
+	icc2 := testProfile(300, 2)
+	var dst2 bytes.Buffer
+	err = EmbedICCtoGIF(&dst2, bytes.NewReader(dst.Bytes()), icc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst2.Len() != src.Len()+14+len(icc2)+2+1 { // header, data, 2 sub-block sizes, terminator
+		t.Fatalf("old profile not removed")
+	}
+	loaded, err = LoadICCfromGIF(bytes.NewReader(dst2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc2) {
+		t.Fatalf("replaced profile mismatch")
+	}
+
+	// pixel data must be intact
+	_, err = gif.Decode(bytes.NewReader(dst2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a GIF87a file is upgraded to GIF89a to hold the extension
+	src87 := append([]byte("GIF87a"), src.Bytes()[6:]...)
+	var dst87 bytes.Buffer
+	err = EmbedICCtoGIF(&dst87, bytes.NewReader(src87), icc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(dst87.Bytes()[:6]); v != "GIF89a" {
+		t.Errorf("GIF version not upgraded: %q", v)
+	}
+	loaded, err = LoadICCfromGIF(bytes.NewReader(dst87.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc) {
+		t.Fatalf("embedded profile of GIF87a mismatch")
+	}
+}
@@ -4,4 +4,4 @@ go 1.17
 
 require github.com/mixcode/binarystruct v0.0.10
 
-require golang.org/x/text v0.3.6 // indirect
+require golang.org/x/text v0.3.6
@@ -0,0 +1,298 @@
+//
+// read embedded ICC profile in a HEIF (HEIC/AVIF) file
+//
+// HEIF spec: ISO/IEC 23008-12
+// the colour information box 'colr': ISO/IEC 14496-12 12.1.5
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// brands of HEIF files
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "hevc": true, "hevx": true,
+	"heim": true, "heis": true, "hevm": true, "hevs": true,
+	"mif1": true, "msf1": true, "avif": true, "avis": true,
+}
+
+// CICP is a color description by coding-independent code points of ITU-T H.273,
+// e.g. {1, 13, 6, true} for sRGB and {12, 13, 6, true} for Display P3.
+type CICP struct {
+	ColourPrimaries         int
+	TransferCharacteristics int
+	MatrixCoefficients      int
+	FullRange               bool
+}
+
+// Read ICC profile embedded in a HEIF file, including HEIC and AVIF.
+// If there is no ICC profile then nil data and no error is returned.
+func LoadICCfromHEIF(in io.ReadSeeker) (iccProfile []byte, err error) {
+	iccProfile, _, err = LoadColorFromHEIF(in)
+	return
+}
+
+// Read the color description of the primary item of a HEIF file, including HEIC and AVIF.
+// iccProfile is the ICC profile of a 'colr' property of type 'prof' or 'rICC',
+// and cicp is the code points of a 'colr' property of type 'nclx'; either one may be nil.
+// If the primary item has no 'colr' property, e.g. an image grid whose tiles have the properties,
+// then 'colr' properties of the items it derives from by 'dimg' references are used.
+// Properties of other items, such as thumbnails or auxiliary images, are never used.
+func LoadColorFromHEIF(in io.ReadSeeker) (iccProfile []byte, cicp *CICP, err error) {
+	boxes, err := readTopISOBoxes(in)
+	if err != nil {
+		return
+	}
+	ftyp, ok := findISOBox(boxes, "ftyp")
+	if !ok {
+		err = fmt.Errorf("ftyp box not found")
+		return
+	}
+	ftypData, err := readISOBoxData(in, ftyp)
+	if err != nil {
+		return
+	}
+	isHEIF := false
+	for i := 0; i+4 <= len(ftypData); i += 4 {
+		if i == 4 { // minor version
+			continue
+		}
+		isHEIF = isHEIF || heifBrands[string(ftypData[i:i+4])]
+	}
+	if !isHEIF {
+		err = fmt.Errorf("not a HEIF file")
+		return
+	}
+
+	meta, ok := findISOBox(boxes, "meta")
+	if !ok {
+		err = fmt.Errorf("meta box not found")
+		return
+	}
+	metaBoxes, err := readISOChildBoxes(in, meta, 4) // FullBox version and flags
+	if err != nil {
+		return
+	}
+
+	// the primary item
+	primary := -1
+	if pitm, ok := findISOBox(metaBoxes, "pitm"); ok {
+		var data []byte
+		data, err = readISOBoxData(in, pitm)
+		if err != nil {
+			return
+		}
+		switch {
+		case len(data) >= 6 && data[0] == 0:
+			primary = int(bst.BigEndian.Uint16(data[4:]))
+		case len(data) >= 8:
+			primary = int(bst.BigEndian.Uint32(data[4:]))
+		default:
+			err = fmt.Errorf("pitm box too short")
+			return
+		}
+	}
+
+	iprp, ok := findISOBox(metaBoxes, "iprp")
+	if !ok {
+		// no properties
+		return
+	}
+	iprpBoxes, err := readISOChildBoxes(in, iprp, 0)
+	if err != nil {
+		return
+	}
+	ipco, ok := findISOBox(iprpBoxes, "ipco")
+	if !ok {
+		return
+	}
+	properties, err := readISOChildBoxes(in, ipco, 0)
+	if err != nil {
+		return
+	}
+
+	// properties associated with items; indices are 1-based
+	itemProps := make(map[int][]int)
+	for _, ipma := range iprpBoxes {
+		if ipma.Type != "ipma" {
+			continue
+		}
+		var data []byte
+		data, err = readISOBoxData(in, ipma)
+		if err != nil {
+			return
+		}
+		err = parseHEIFItemProperties(data, itemProps)
+		if err != nil {
+			return
+		}
+	}
+
+	colrOf := func(item int) (colr []isoBox) {
+		for _, index := range itemProps[item] {
+			if index >= 1 && index <= len(properties) && properties[index-1].Type == "colr" {
+				colr = append(colr, properties[index-1])
+			}
+		}
+		return
+	}
+	colr := colrOf(primary)
+	if len(colr) == 0 {
+		// derived images such as grids take the properties of their input images
+		var dimg map[int][]int
+		if iref, ok := findISOBox(metaBoxes, "iref"); ok {
+			var data []byte
+			data, err = readISOBoxData(in, iref)
+			if err != nil {
+				return
+			}
+			dimg, err = parseHEIFItemReferences(data, "dimg")
+			if err != nil {
+				return
+			}
+		}
+		visited := map[int]bool{primary: true}
+		queue := dimg[primary]
+		for len(colr) == 0 && len(queue) > 0 {
+			item := queue[0]
+			queue = queue[1:]
+			if visited[item] {
+				continue
+			}
+			visited[item] = true
+			colr = colrOf(item)
+			queue = append(queue, dimg[item]...)
+		}
+	}
+
+	for _, box := range colr {
+		var data []byte
+		data, err = readISOBoxData(in, box)
+		if err != nil {
+			return
+		}
+		if len(data) < 4 {
+			err = fmt.Errorf("colr box too short")
+			return
+		}
+		switch string(data[:4]) {
+		case "prof", "rICC":
+			if iccProfile == nil {
+				iccProfile = data[4:]
+			}
+		case "nclx":
+			if len(data) < 11 {
+				err = fmt.Errorf("colr box too short")
+				return
+			}
+			if cicp == nil {
+				be := bst.BigEndian
+				cicp = &CICP{
+					ColourPrimaries:         int(be.Uint16(data[4:])),
+					TransferCharacteristics: int(be.Uint16(data[6:])),
+					MatrixCoefficients:      int(be.Uint16(data[8:])),
+					FullRange:               data[10]&0x80 != 0,
+				}
+			}
+		}
+	}
+	return
+}
+
+// parse an item property association box 'ipma', adding property indices of items to itemProps
+func parseHEIFItemProperties(data []byte, itemProps map[int][]int) (err error) {
+	if len(data) < 8 {
+		return fmt.Errorf("ipma box too short")
+	}
+	be := bst.BigEndian
+	version, flags := data[0], data[3]
+	count := int(be.Uint32(data[4:]))
+	p := 8
+	for i := 0; i < count; i++ {
+		var item int
+		if version < 1 {
+			if p+2 > len(data) {
+				return fmt.Errorf("ipma box too short")
+			}
+			item = int(be.Uint16(data[p:]))
+			p += 2
+		} else {
+			if p+4 > len(data) {
+				return fmt.Errorf("ipma box too short")
+			}
+			item = int(be.Uint32(data[p:]))
+			p += 4
+		}
+		if p+1 > len(data) {
+			return fmt.Errorf("ipma box too short")
+		}
+		n := int(data[p])
+		p++
+		for j := 0; j < n; j++ {
+			var index int
+			if flags&1 != 0 { // 1-bit essential flag and 15-bit index
+				if p+2 > len(data) {
+					return fmt.Errorf("ipma box too short")
+				}
+				index = int(be.Uint16(data[p:]) & 0x7fff)
+				p += 2
+			} else { // 1-bit essential flag and 7-bit index
+				if p+1 > len(data) {
+					return fmt.Errorf("ipma box too short")
+				}
+				index = int(data[p] & 0x7f)
+				p++
+			}
+			itemProps[item] = append(itemProps[item], index)
+		}
+	}
+	return
+}
+
+// parse an item reference box 'iref', returning the referenced items of each item for a reference type
+func parseHEIFItemReferences(data []byte, refType string) (refs map[int][]int, err error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("iref box too short")
+	}
+	be := bst.BigEndian
+	idSize := 2
+	if data[0] != 0 {
+		idSize = 4
+	}
+	readID := func(b []byte) int {
+		if idSize == 2 {
+			return int(be.Uint16(b))
+		}
+		return int(be.Uint32(b))
+	}
+	refs = make(map[int][]int)
+	p := 4
+	for p+8 <= len(data) {
+		size, boxType := int(be.Uint32(data[p:])), string(data[p+4:p+8])
+		if size < 8 || p+size > len(data) {
+			return nil, fmt.Errorf("iref box out of range")
+		}
+		box := data[p+8 : p+size]
+		p += size
+		if boxType != refType {
+			continue
+		}
+		if len(box) < idSize+2 {
+			return nil, fmt.Errorf("iref box too short")
+		}
+		from, count := readID(box), int(be.Uint16(box[idSize:]))
+		box = box[idSize+2:]
+		if len(box) < count*idSize {
+			return nil, fmt.Errorf("iref box too short")
+		}
+		for i := 0; i < count; i++ {
+			refs[from] = append(refs[from], readID(box[i*idSize:]))
+		}
+	}
+	return
+}
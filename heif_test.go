@@ -0,0 +1,106 @@
+package imageicc
+
+import (
+	"bytes"
+	"testing"
+)
+
+// a HEIF file of two items; the primary item 2 has properties of colrIndices.
+// Item 1 is a tile of the primary item with the profile icc if tile is set,
+// or a thumbnail with a different profile otherwise.
+func testHEIF(icc []byte, tile bool, colrIndices ...byte) []byte {
+	ftyp := testBox("ftyp", []byte("mif1\x00\x00\x00\x00mif1heic"))
+	pitm := testBox("pitm", []byte{0, 0, 0, 0, 0, 2})
+	ipco := testBox("ipco",
+		testBox("ispe", make([]byte, 12)),
+		testBox("colr", []byte("nclx\x00\x0c\x00\x0d\x00\x06\x80")),
+		testBox("colr", []byte("prof"), icc),
+		testBox("colr", []byte("prof"), testProfile(200, 9)),
+	)
+	// item 1: properties 1 and 3 or 4; item 2: colrIndices
+	ref, item1Colr := testBox("thmb", []byte{0, 1, 0, 1, 0, 2}), byte(0x84)
+	if tile {
+		ref, item1Colr = testBox("dimg", []byte{0, 2, 0, 1, 0, 1}), 0x83
+	}
+	ipma := []byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 2, 0x81, item1Colr, 0, 2, byte(len(colrIndices))}
+	ipma = append(ipma, colrIndices...)
+	meta := testBox("meta", []byte{0, 0, 0, 0},
+		testBox("hdlr", make([]byte, 24)),
+		pitm,
+		testBox("iref", []byte{0, 0, 0, 0}, ref),
+		testBox("iprp", ipco, testBox("ipma", ipma)),
+	)
+	// mdat of 64-bit size
+	mdat := []byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 20, 1, 2, 3, 4}
+	return append(append(ftyp, meta...), mdat...)
+}
+
+func TestLoadICCfromHEIF(t *testing.T) {
+	icc := testProfile(1500, 3)
+
+	// ICC profile and code points
+	b := testHEIF(icc, false, 0x81, 0x02, 0x83)
+	loaded, cicp, err := LoadColorFromHEIF(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc) {
+		t.Errorf("loaded profile differs")
+	}
+	if cicp == nil || *cicp != (CICP{12, 13, 6, true}) {
+		t.Errorf("wrong code points %+v", cicp)
+	}
+	loaded, format, err := LoadICC(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != FormatHEIF || !bytes.Equal(loaded, icc) {
+		t.Errorf("LoadICC: %v, %d bytes", format, len(loaded))
+	}
+
+	// code points only; the profile of the thumbnail is not used
+	loaded, cicp, err = LoadColorFromHEIF(bytes.NewReader(testHEIF(icc, false, 0x02)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != nil || cicp == nil {
+		t.Errorf("code points only: %d bytes, %+v", len(loaded), cicp)
+	}
+
+	// no colr property of the primary item, and a thumbnail with a different profile
+	loaded, cicp, err = LoadColorFromHEIF(bytes.NewReader(testHEIF(icc, false, 0x01)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != nil || cicp != nil {
+		t.Errorf("thumbnail profile loaded: %d bytes, %+v", len(loaded), cicp)
+	}
+
+	// no colr property of the primary item, which is derived from a tile with the profile
+	loaded, err = LoadICCfromHEIF(bytes.NewReader(testHEIF(icc, true, 0x01)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc) {
+		t.Errorf("tile profile not loaded")
+	}
+
+	// truncated
+	if _, err = LoadICCfromHEIF(bytes.NewReader(b[:200])); err == nil {
+		t.Errorf("truncated file accepted")
+	}
+}
+
+func TestLibheifSample(t *testing.T) {
+	// HEVC and AV1 images written by libheif with a raw colour profile
+	want := testSample(t, "display-p3-v2.icc")
+	for _, name := range []string{"libheif-display-p3.heic", "libheif-display-p3.avif"} {
+		icc, format, err := LoadICC(bytes.NewReader(testSample(t, name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != FormatHEIF || !bytes.Equal(icc, want) {
+			t.Errorf("%s: %v profile of %d bytes differs from the source profile", name, format, len(icc))
+		}
+	}
+}
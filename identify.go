@@ -0,0 +1,214 @@
+//
+// identify well-known profiles
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ProfileMatch is the way a profile is identified.
+type ProfileMatch int
+
+const (
+	MatchProfileID    ProfileMatch = iota // the stored profile ID is a known one
+	MatchContent                          // the MD5 digest of the profile content is a known one
+	MatchColorimetric                     // the profile converts colors identically to a standard profile
+)
+
+func (m ProfileMatch) String() string {
+	switch m {
+	case MatchProfileID:
+		return "profile ID"
+	case MatchContent:
+		return "content"
+	case MatchColorimetric:
+		return "colorimetric"
+	}
+	return fmt.Sprintf("ProfileMatch(%d)", int(m))
+}
+
+// KnownProfile is the identity of a well-known profile.
+type KnownProfile struct {
+	Name     string          // name of the profile, e.g. "sRGB IEC61966-2.1"
+	Standard StandardProfile // the color space the profile is equivalent to
+	Match    ProfileMatch    // how the profile is identified
+}
+
+// a registered profile
+type knownProfileEntry struct {
+	name     string
+	standard StandardProfile
+}
+
+var (
+	knownProfileMutex sync.Mutex
+	knownProfileOnce  sync.Once
+	knownProfileIDs   = map[[16]byte]knownProfileEntry{} // by the profile ID, which is the MD5 of the content
+)
+
+// descriptions of well-known vendor profiles and the standard color spaces they are meant to be.
+// A profile of such a description is compared to that standard first, but reported by the
+// standard name, as vendors reuse the descriptions for profiles of different content.
+var wellKnownDescriptions = map[string]StandardProfile{
+	"sRGB IEC61966-2.1":              ProfileSRGB,
+	"Display P3":                     ProfileDisplayP3,
+	"Adobe RGB (1998)":               ProfileAdobeRGB,
+	"Generic Gray Gamma 2.2 Profile": ProfileGray22,
+}
+
+// register the built-in profiles
+func registerStandardProfiles() {
+	for sp := range standardProfiles {
+		for _, version := range []int{2, 4} {
+			b, err := StandardICC(sp, version)
+			if err != nil {
+				continue
+			}
+			knownProfileIDs[profileID(b)] = knownProfileEntry{fmt.Sprintf("%s (imageicc v%d)", sp, version), sp}
+		}
+	}
+}
+
+// RegisterKnownProfile registers a profile to be identified by its content,
+// e.g. a vendor's sRGB profile, as equivalent to a standard color space.
+func RegisterKnownProfile(name string, standard StandardProfile, iccProfile []byte) (err error) {
+	if _, ok := standardProfiles[standard]; !ok {
+		err = fmt.Errorf("unknown standard profile %d", int(standard))
+		return
+	}
+	id, err := ComputeProfileID(iccProfile)
+	if err != nil {
+		return
+	}
+	knownProfileOnce.Do(registerStandardProfiles)
+	knownProfileMutex.Lock()
+	defer knownProfileMutex.Unlock()
+	knownProfileIDs[id] = knownProfileEntry{name, standard}
+	return
+}
+
+// find a registered profile by the profile ID
+func lookupKnownProfile(id [16]byte) (e knownProfileEntry, ok bool) {
+	knownProfileOnce.Do(registerStandardProfiles)
+	knownProfileMutex.Lock()
+	defer knownProfileMutex.Unlock()
+	e, ok = knownProfileIDs[id]
+	return
+}
+
+// order of standard profiles to be compared; sRGB, the most common one, first
+var identifyOrder = []StandardProfile{
+	ProfileSRGB, ProfileDisplayP3, ProfileAdobeRGB, ProfileProPhotoRGB, ProfileRec2020,
+	ProfileGraySRGB, ProfileGray22,
+}
+
+// maximum difference of device values of colorimetrically equivalent profiles
+const identifyTolerance = 1.0 / 255
+
+// number of samples of each channel compared
+const identifySamples = 9
+
+var (
+	standardStagesOnce sync.Once
+	standardStages     map[StandardProfile]*pcsStage // PCS stages of the v4 standard profiles
+)
+
+// build the PCS stages of the standard profiles once
+func buildStandardStages() {
+	standardStages = make(map[StandardProfile]*pcsStage)
+	for sp := range standardProfiles {
+		b, err := StandardICC(sp, 4)
+		if err != nil {
+			continue
+		}
+		std, err := ParseProfile(b)
+		if err != nil {
+			continue
+		}
+		st, err := newPCSStage(std, IntentRelativeColorimetric, &TransformOptions{})
+		if err != nil {
+			continue
+		}
+		standardStages[sp] = st
+	}
+}
+
+// report whether a profile, given by its PCS stage, converts colors to a standard profile without changes
+func colorimetricEqual(s *pcsStage, sp StandardProfile) bool {
+	standardStagesOnce.Do(buildStandardStages)
+	d, ok := standardStages[sp]
+	if !ok || d.colorSpace != s.colorSpace {
+		return false
+	}
+	t, err := newTransform(s, d, IntentRelativeColorimetric, &TransformOptions{})
+	if err != nil {
+		return false
+	}
+	channels := t.InputChannels()
+	n := int(math.Pow(identifySamples, float64(channels)))
+	in := make([]float64, channels)
+	for i := 0; i < n; i++ {
+		k := i
+		for c := range in {
+			in[c] = float64(k%identifySamples) / (identifySamples - 1)
+			k /= identifySamples
+		}
+		out := t.Convert(in)
+		for c := range in {
+			if math.Abs(out[c]-in[c]) > identifyTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IdentifyProfile identifies a well-known profile.
+// A profile is looked up by its stored profile ID and by the MD5 digest of its content,
+// and then compared colorimetrically to the standard profiles.
+// If the profile is not identified then nil and no error is returned.
+func IdentifyProfile(iccProfile []byte) (known *KnownProfile, err error) {
+	p, err := ParseProfile(iccProfile)
+	if err != nil {
+		return
+	}
+	if p.Header.ProfileID != ([16]byte{}) {
+		if e, ok := lookupKnownProfile(p.Header.ProfileID); ok {
+			return &KnownProfile{e.name, e.standard, MatchProfileID}, nil
+		}
+	}
+	if e, ok := lookupKnownProfile(profileID(p.data)); ok {
+		return &KnownProfile{e.name, e.standard, MatchContent}, nil
+	}
+
+	s, err := newPCSStage(p, IntentRelativeColorimetric, &TransformOptions{})
+	if err != nil {
+		// not convertible, e.g. a device link or an unsupported color space
+		return nil, nil
+	}
+	if desc, _ := p.Description(); desc != "" {
+		if sp, ok := wellKnownDescriptions[desc]; ok && colorimetricEqual(s, sp) {
+			return &KnownProfile{sp.String(), sp, MatchColorimetric}, nil
+		}
+	}
+	for _, sp := range identifyOrder {
+		if colorimetricEqual(s, sp) {
+			return &KnownProfile{sp.String(), sp, MatchColorimetric}, nil
+		}
+	}
+	return
+}
+
+// IsSRGB reports whether a profile is equivalent to sRGB.
+// Images with such a profile need no color conversion to be displayed as sRGB.
+func IsSRGB(iccProfile []byte) (bool, error) {
+	known, err := IdentifyProfile(iccProfile)
+	if err != nil || known == nil {
+		return false, err
+	}
+	return known.Standard == ProfileSRGB, nil
+}
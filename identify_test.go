@@ -0,0 +1,97 @@
+package imageicc
+
+import (
+	"testing"
+)
+
+func TestIdentifyProfile(t *testing.T) {
+	for _, sp := range identifyOrder {
+		for version, match := range map[int]ProfileMatch{2: MatchContent, 4: MatchProfileID} {
+			b, _ := StandardICC(sp, version)
+			known, err := IdentifyProfile(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if known == nil || known.Standard != sp || known.Match != match {
+				t.Errorf("%v v%d: identified as %+v", sp, version, known)
+			}
+		}
+	}
+
+	// equivalent profiles of different content
+	for _, c := range []struct {
+		pb   ProfileBuilder
+		want StandardProfile
+	}{
+		{ProfileBuilder{Version: 2, Primaries: [3]Chromaticity{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}},
+			White: whiteD65, Curves: []Curve{srgbCurve}, Description: "sRGB IEC61966-2.1"}, ProfileSRGB},
+		{ProfileBuilder{Primaries: [3]Chromaticity{{0.68, 0.32}, {0.265, 0.69}, {0.15, 0.06}},
+			White: whiteD65, Curves: []Curve{srgbCurve}, Description: "Display P3"}, ProfileDisplayP3},
+		{ProfileBuilder{ColorSpace: ColorSpaceGray, White: whiteD65, Curves: []Curve{GammaCurve(2.2)}, Description: "Generic Gray"}, ProfileGray22},
+		{ProfileBuilder{ColorSpace: ColorSpaceGray, Curves: []Curve{srgbCurve}}, ProfileGraySRGB},
+	} {
+		b, err := c.pb.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		known, err := IdentifyProfile(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if known == nil || known.Standard != c.want || known.Match != MatchColorimetric {
+			t.Errorf("%q: identified as %+v, want %v", c.pb.Description, known, c.want)
+		}
+	}
+
+	// well-known descriptions are reported by the standard name
+	for _, c := range []struct {
+		pb   ProfileBuilder
+		name string
+	}{
+		{ProfileBuilder{Version: 2, Primaries: [3]Chromaticity{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}},
+			White: whiteD65, Curves: []Curve{srgbCurve}, Description: "sRGB IEC61966-2.1"}, ProfileSRGB.String()},
+		{ProfileBuilder{Primaries: [3]Chromaticity{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}},
+			White: whiteD65, Curves: []Curve{GammaCurve(563.0 / 256)}, Description: "Adobe RGB (1998)"}, ProfileAdobeRGB.String()},
+		// a matching description with other colorimetry is not trusted
+		{ProfileBuilder{Primaries: [3]Chromaticity{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}},
+			White: whiteD65, Curves: []Curve{srgbCurve}, Description: "Display P3"}, ProfileSRGB.String()},
+	} {
+		b, err := c.pb.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if known, _ := IdentifyProfile(b); known == nil || known.Name != c.name || known.Match != MatchColorimetric {
+			t.Errorf("%q: identified as %+v, want %q", c.pb.Description, known, c.name)
+		}
+	}
+
+	// sRGB primaries with a wrong curve
+	pb := ProfileBuilder{Primaries: [3]Chromaticity{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}}, White: whiteD65, Curves: []Curve{GammaCurve(1.8)}}
+	b, _ := pb.Build()
+	if ok, err := IsSRGB(b); ok || err != nil {
+		t.Errorf("gamma 1.8 profile is sRGB: %v", err)
+	}
+	if known, _ := IdentifyProfile(b); known != nil {
+		t.Errorf("gamma 1.8 profile identified as %+v", known)
+	}
+
+	// registered profile
+	err := RegisterKnownProfile("Vendor sRGB", ProfileSRGB, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		knownProfileMutex.Lock()
+		defer knownProfileMutex.Unlock()
+		delete(knownProfileIDs, profileID(b))
+	})
+	if known, _ := IdentifyProfile(b); known == nil || known.Name != "Vendor sRGB" {
+		t.Errorf("registered profile identified as %+v", known)
+	}
+
+	// CMYK
+	cmyk := testCMYKProfile(t, testTag{"A2B0", testCMYKLut(100, 0)})
+	if known, err := IdentifyProfile(cmyk.Bytes()); known != nil || err != nil {
+		t.Errorf("CMYK profile identified as %+v, %v", known, err)
+	}
+}
@@ -0,0 +1,108 @@
+//
+// read the box structure of ISO base media file format and JPEG 2000 files
+//
+// ISO/IEC 14496-12, ISO/IEC 15444-1 Annex I
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// a box. {Size, Type, [LargeSize], [DATA]}
+type isoBox struct {
+	Type   string // box type, 4-char code
+	Offset int64  // offset of the box data in the file, after the header
+	Size   int64  // size of the box data
+}
+
+// the header of a box
+type isoBoxHeader struct {
+	Size int    `binary:"uint32"` // box size including the header; 1 for a 64-bit size, 0 for to the end
+	Type string `binary:"[4]byte"`
+}
+
+// read the boxes between start and end offsets of a stream
+func readISOBoxes(in io.ReadSeeker, start, end int64) (boxes []isoBox, err error) {
+	offset := start
+	for offset+8 <= end {
+		_, err = in.Seek(offset, io.SeekStart)
+		if err != nil {
+			return
+		}
+		var h isoBoxHeader
+		_, err = bst.Read(in, bst.BigEndian, &h)
+		if err != nil {
+			return
+		}
+		box := isoBox{Type: h.Type, Offset: offset + 8}
+		switch h.Size {
+		case 0: // to the end
+			box.Size = end - box.Offset
+		case 1: // 64-bit size follows
+			var large uint64
+			_, err = bst.Read(in, bst.BigEndian, &large)
+			if err != nil {
+				return
+			}
+			box.Offset += 8
+			box.Size = int64(large) - 16
+		default:
+			box.Size = int64(h.Size) - 8
+		}
+		if box.Size < 0 || box.Offset+box.Size > end || box.Offset+box.Size < box.Offset {
+			err = fmt.Errorf("box %q out of range", h.Type)
+			return
+		}
+		boxes = append(boxes, box)
+		offset = box.Offset + box.Size
+	}
+	return
+}
+
+// read the boxes in a stream, to the end of the stream
+func readTopISOBoxes(in io.ReadSeeker) (boxes []isoBox, err error) {
+	start, err := in.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	end, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	return readISOBoxes(in, start, end)
+}
+
+// read the child boxes of a box. skip is the size of fields before the children.
+func readISOChildBoxes(in io.ReadSeeker, parent isoBox, skip int64) (boxes []isoBox, err error) {
+	if parent.Size < skip {
+		err = fmt.Errorf("box %q too short", parent.Type)
+		return
+	}
+	return readISOBoxes(in, parent.Offset+skip, parent.Offset+parent.Size)
+}
+
+// read the data of a box
+func readISOBoxData(in io.ReadSeeker, box isoBox) (data []byte, err error) {
+	_, err = in.Seek(box.Offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+	data = make([]byte, box.Size)
+	_, err = io.ReadFull(in, data)
+	return
+}
+
+// find the first box of a type
+func findISOBox(boxes []isoBox, boxType string) (box isoBox, ok bool) {
+	for _, b := range boxes {
+		if b.Type == boxType {
+			return b, true
+		}
+	}
+	return
+}
@@ -0,0 +1,16 @@
+package imageicc
+
+import (
+	bst "github.com/mixcode/binarystruct"
+)
+
+// an ISOBMFF box
+func testBox(boxType string, data ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], boxType)
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	bst.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
@@ -0,0 +1,145 @@
+//
+// read embedded ICC profile in a JPEG 2000 (JP2/JPX) file
+//
+// JP2 file format: ISO/IEC 15444-1 Annex I, JPX: ISO/IEC 15444-2 Annex M
+//
+
+package imageicc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+var (
+	jp2Signature  = []byte("\x00\x00\x00\x0cjP  \r\n\x87\n") // the JPEG 2000 signature box
+	j2kCodestream = []byte{0xff, 0x4f, 0xff, 0x51}           // SOC and SIZ markers of a bare codestream
+)
+
+// specification methods of the colour specification box
+const (
+	jp2MethodEnumerated    = 1 // enumerated colourspace
+	jp2MethodRestrictedICC = 2 // restricted ICC profile
+	jp2MethodAnyICC        = 3 // any ICC profile (JPX)
+)
+
+// JP2ColorSpace is an enumerated colourspace of JPEG 2000.
+type JP2ColorSpace int
+
+const (
+	JP2ColorSpaceNone    JP2ColorSpace = -1 // no enumerated colourspace
+	JP2ColorSpaceCMYK    JP2ColorSpace = 12
+	JP2ColorSpaceCIELab  JP2ColorSpace = 14
+	JP2ColorSpaceSRGB    JP2ColorSpace = 16
+	JP2ColorSpaceGray    JP2ColorSpace = 17
+	JP2ColorSpaceSYCC    JP2ColorSpace = 18
+	JP2ColorSpaceESRGB   JP2ColorSpace = 20
+	JP2ColorSpaceROMMRGB JP2ColorSpace = 21
+)
+
+var jp2ColorSpaceName = map[JP2ColorSpace]string{
+	JP2ColorSpaceNone:    "none",
+	0:                    "bi-level",
+	1:                    "YCbCr(1)",
+	3:                    "YCbCr(2)",
+	4:                    "YCbCr(3)",
+	9:                    "PhotoYCC",
+	11:                   "CMY",
+	JP2ColorSpaceCMYK:    "CMYK",
+	13:                   "YCCK",
+	JP2ColorSpaceCIELab:  "CIELab",
+	15:                   "bi-level(2)",
+	JP2ColorSpaceSRGB:    "sRGB",
+	JP2ColorSpaceGray:    "greyscale",
+	JP2ColorSpaceSYCC:    "sYCC",
+	19:                   "CIEJab",
+	JP2ColorSpaceESRGB:   "e-sRGB",
+	JP2ColorSpaceROMMRGB: "ROMM-RGB",
+	22:                   "YPbPr(1125/60)",
+	23:                   "YPbPr(1250/50)",
+	24:                   "e-sYCC",
+}
+
+func (cs JP2ColorSpace) String() string {
+	if s, ok := jp2ColorSpaceName[cs]; ok {
+		return s
+	}
+	return fmt.Sprintf("JP2ColorSpace(%d)", int(cs))
+}
+
+// Read ICC profile embedded in a JPEG 2000 file.
+// If there is no ICC profile then nil data and no error is returned.
+func LoadICCfromJP2(in io.ReadSeeker) (iccProfile []byte, err error) {
+	iccProfile, _, err = LoadColorFromJP2(in)
+	return
+}
+
+// Read the colour specification of a JPEG 2000 file, from 'colr' boxes in the JP2 header box.
+// iccProfile is a restricted (METH 2) or any (METH 3) ICC profile.
+// If there is no ICC profile then the enumerated colourspace (METH 1) is returned as colorSpace;
+// otherwise colorSpace is JP2ColorSpaceNone.
+// A bare codestream has no colour specification, and nil data and JP2ColorSpaceNone are returned.
+func LoadColorFromJP2(in io.ReadSeeker) (iccProfile []byte, colorSpace JP2ColorSpace, err error) {
+	colorSpace = JP2ColorSpaceNone
+
+	h := make([]byte, len(jp2Signature))
+	_, err = io.ReadFull(in, h)
+	if err != nil {
+		return
+	}
+	if bytes.HasPrefix(h, j2kCodestream) {
+		return
+	}
+	if !bytes.Equal(h, jp2Signature) {
+		err = fmt.Errorf("invalid JPEG 2000 signature")
+		return
+	}
+
+	boxes, err := readTopISOBoxes(in)
+	if err != nil {
+		return
+	}
+	jp2h, ok := findISOBox(boxes, "jp2h")
+	if !ok {
+		err = fmt.Errorf("JP2 header box not found")
+		return
+	}
+	header, err := readISOChildBoxes(in, jp2h, 0)
+	if err != nil {
+		return
+	}
+
+	// JPX files may have multiple colour specifications; the first ICC profile is used
+	enumerated := JP2ColorSpaceNone
+	for _, box := range header {
+		if box.Type != "colr" {
+			continue
+		}
+		var data []byte
+		data, err = readISOBoxData(in, box)
+		if err != nil {
+			return
+		}
+		if len(data) < 3 {
+			err = fmt.Errorf("colr box too short")
+			return
+		}
+		// METH, PREC and APPROX
+		switch data[0] {
+		case jp2MethodEnumerated:
+			if len(data) < 7 {
+				err = fmt.Errorf("colr box too short")
+				return
+			}
+			if enumerated == JP2ColorSpaceNone {
+				enumerated = JP2ColorSpace(bst.BigEndian.Uint32(data[3:]))
+			}
+		case jp2MethodRestrictedICC, jp2MethodAnyICC:
+			return data[3:], JP2ColorSpaceNone, nil
+		}
+	}
+	return nil, enumerated, nil
+}
@@ -0,0 +1,54 @@
+package imageicc
+
+import (
+	"bytes"
+	"testing"
+)
+
+// a JP2 file of colour specification boxes
+func testJP2(colr ...[]byte) []byte {
+	b := append([]byte(nil), jp2Signature...)
+	b = append(b, testBox("ftyp", []byte("jp2 \x00\x00\x00\x00jp2 "))...)
+	header := [][]byte{testBox("ihdr", make([]byte, 14))}
+	for _, c := range colr {
+		header = append(header, testBox("colr", c))
+	}
+	b = append(b, testBox("jp2h", header...)...)
+	return append(b, testBox("jp2c", j2kCodestream)...)
+}
+
+func TestLoadICCfromJP2(t *testing.T) {
+	icc := testProfile(1200, 4)
+	enumSRGB := []byte{1, 0, 0, 0, 0, 0, 16}
+
+	tests := []struct {
+		file []byte
+		icc  []byte
+		cs   JP2ColorSpace
+	}{
+		{testJP2(append([]byte{2, 0, 0}, icc...)), icc, JP2ColorSpaceNone},
+		{testJP2(enumSRGB, append([]byte{3, 0, 1}, icc...)), icc, JP2ColorSpaceNone},
+		{testJP2(enumSRGB), nil, JP2ColorSpaceSRGB},
+		{testJP2([]byte{4, 0, 0, 1, 2, 3, 4}), nil, JP2ColorSpaceNone}, // vendor colour method
+		{append(append([]byte(nil), j2kCodestream...), make([]byte, 40)...), nil, JP2ColorSpaceNone},
+	}
+	for i, tc := range tests {
+		loaded, cs, err := LoadColorFromJP2(bytes.NewReader(tc.file))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if !bytes.Equal(loaded, tc.icc) || cs != tc.cs {
+			t.Errorf("case %d: %d bytes, %v; want %d bytes, %v", i, len(loaded), cs, len(tc.icc), tc.cs)
+		}
+		loaded, format, err := LoadICC(bytes.NewReader(tc.file))
+		if err != nil || format != FormatJP2 || !bytes.Equal(loaded, tc.icc) {
+			t.Errorf("case %d: LoadICC %v, %v", i, format, err)
+		}
+	}
+
+	// no JP2 header box
+	b := append(append([]byte(nil), jp2Signature...), testBox("jp2c", j2kCodestream)...)
+	if _, err := LoadICCfromJP2(bytes.NewReader(b)); err == nil {
+		t.Errorf("JP2 without header accepted")
+	}
+}
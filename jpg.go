@@ -1,5 +1,5 @@
 //
-// read embedded ICC profile from a jpg file
+// read and write embedded ICC profile in a jpg file
 //
 // jpeg/JFIF format spec
 // https://www.iso.org/standard/54989.html
@@ -34,6 +34,7 @@ const (
 	// but in practice, their use is described at
 	// https://www.sno.phy.queensu.ca/~phil/exiftool/TagNames/JPEG.html
 	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
 	markerAPP2  = 0xe2
 	markerAPP14 = 0xee
 	markerAPP15 = 0xef
@@ -139,6 +140,7 @@ func LoadICCfromJPG(in io.ReadSeeker) (iccProfile []byte, err error) {
 					if err != nil {
 						return
 					}
+					segLen = 0
 					iccLastIndex++
 
 					if iccLastIndex == iccIndexMax {
@@ -240,3 +242,165 @@ func skipSOS(in io.ReadSeeker, numComponents int, headerLen int) (n int, err err
 	}
 	return readSz, nil
 }
+
+const (
+	// ICC_PROFILE chunk header is {"ICC_PROFILE\0", chunknum, chunkmax}
+	jpgICCHeaderLen = 0x0e
+	// max length of ICC profile data in a single APP2 segment
+	jpgICCChunkMax = 0xffff - 2 - jpgICCHeaderLen
+)
+
+// write a segment with a marker and a length field
+func writeJPGSegment(w io.Writer, marker byte, data []byte) (err error) {
+	segLen := len(data) + 2
+	if segLen > 0xffff {
+		err = fmt.Errorf("segment too large")
+		return
+	}
+	_, err = w.Write([]byte{0xff, marker, byte(segLen >> 8), byte(segLen)})
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
+
+// write an ICC profile as a sequence of APP2 ICC_PROFILE segments
+func writeJPGICC(w io.Writer, iccProfile []byte) (err error) {
+	count := (len(iccProfile) + jpgICCChunkMax - 1) / jpgICCChunkMax
+	if count > 0xff {
+		err = fmt.Errorf("icc profile too large")
+		return
+	}
+	seg := make([]byte, 0, jpgICCHeaderLen+jpgICCChunkMax)
+	for i := 0; i < count; i++ {
+		chunk := iccProfile[i*jpgICCChunkMax:]
+		if len(chunk) > jpgICCChunkMax {
+			chunk = chunk[:jpgICCChunkMax]
+		}
+		seg = append(seg[:0], "ICC_PROFILE\x00"...)
+		seg = append(seg, byte(i+1), byte(count))
+		seg = append(seg, chunk...)
+		err = writeJPGSegment(w, markerAPP2, seg)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// copy a jpg stream, removing existing ICC profile segments
+// and inserting a new profile if iccProfile is not nil.
+func rewriteJPGICC(out io.Writer, in io.Reader, iccProfile []byte) (err error) {
+
+	buf := make([]byte, 4)
+
+	// Read jpg SOI
+	_, err = io.ReadFull(in, buf[:2])
+	if err != nil {
+		return
+	}
+	if buf[0] != 0xff || buf[1] != markerSOI { // 0xff 0xd8, Start of Image marker
+		err = fmt.Errorf("start-of-image marker not found")
+		return
+	}
+	_, err = out.Write(buf[:2])
+	if err != nil {
+		return
+	}
+
+	iccWritten := iccProfile == nil
+	for {
+		// read segment marker
+		_, err = io.ReadFull(in, buf[:2])
+		if err != nil {
+			return
+		}
+		if buf[0] != 0xff {
+			err = fmt.Errorf("unaligned segment header")
+			return
+		}
+		for buf[1] == 0xff { // skip fill bytes
+			_, err = io.ReadFull(in, buf[1:2])
+			if err != nil {
+				return
+			}
+		}
+		marker := buf[1]
+
+		// the new profile goes right after the JFIF and Exif headers
+		if !iccWritten && marker != markerAPP0 && marker != markerAPP1 {
+			err = writeJPGICC(out, iccProfile)
+			if err != nil {
+				return
+			}
+			iccWritten = true
+		}
+
+		if marker == markerEOI || (markerRST0 <= marker && marker <= markerRST7) {
+			// standalone markers without a length field
+			_, err = out.Write(buf[:2])
+			if err != nil {
+				return
+			}
+			if marker == markerEOI {
+				break
+			}
+			continue
+		}
+
+		// read segment length
+		_, err = io.ReadFull(in, buf[2:4])
+		if err != nil {
+			return
+		}
+		segLen := int(buf[2])<<8 + int(buf[3]) - 2 // segment length includes the length itself
+		if segLen < 0 {
+			err = fmt.Errorf("invalid segment length")
+			return
+		}
+		seg := make([]byte, segLen)
+		_, err = io.ReadFull(in, seg)
+		if err != nil {
+			return
+		}
+
+		if marker == markerAPP2 && len(seg) >= jpgICCHeaderLen && string(seg[:0x0b]) == "ICC_PROFILE" {
+			// drop the existing ICC profile
+			continue
+		}
+
+		_, err = out.Write(buf[:4])
+		if err != nil {
+			return
+		}
+		_, err = out.Write(seg)
+		if err != nil {
+			return
+		}
+
+		if marker == markerSOS {
+			// no more metadata after the start of scan; copy the rest as is
+			_, err = io.Copy(out, in)
+			return
+		}
+	}
+
+	return
+}
+
+// Copy a JPG file from in to out, embedding an ICC profile.
+// Any ICC profile already in the file is replaced.
+// Large profiles are split into multiple APP2 segments.
+func EmbedICCtoJPG(out io.Writer, in io.Reader, iccProfile []byte) (err error) {
+	if len(iccProfile) == 0 {
+		err = fmt.Errorf("empty icc profile")
+		return
+	}
+	return rewriteJPGICC(out, in, iccProfile)
+}
+
+// Copy a JPG file from in to out, removing the embedded ICC profile.
+func StripICCfromJPG(out io.Writer, in io.Reader) (err error) {
+	return rewriteJPGICC(out, in, nil)
+}
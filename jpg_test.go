@@ -0,0 +1,97 @@
+package imageicc
+
+import (
+	"bytes"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+func TestEmbedICCtoJPG(t *testing.T) {
+	var src bytes.Buffer
+	err := jpeg.Encode(&src, testImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a profile large enough to be split into multiple segments
+	icc := testProfile(150000, 1)
+	var dst bytes.Buffer
+	err = EmbedICCtoJPG(&dst, bytes.NewReader(src.Bytes()), icc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadICCfromJPG(bytes.NewReader(dst.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc) {
+		t.Fatalf("embedded profile mismatch")
+	}
+
+	// replace the profile with a smaller one
+	icc2 := testProfile(1000, 2)
+	var dst2 bytes.Buffer
+	err = EmbedICCtoJPG(&dst2, bytes.NewReader(dst.Bytes()), icc2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = LoadICCfromJPG(bytes.NewReader(dst2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc2) {
+		t.Fatalf("replaced profile mismatch")
+	}
+
+	// pixel data must be intact
+	_, err = jpeg.Decode(bytes.NewReader(dst2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLibjpegSample(t *testing.T) {
+	// written by libjpeg-turbo's jpeg_write_icc_profile, split into two APP2 segments
+	src := testSample(t, "libjpeg-large-icc.jpg")
+	want := testSample(t, "large-v2.icc")
+	loaded, err := LoadICCfromJPG(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, want) {
+		t.Fatalf("profile of %d bytes differs from the source profile", len(loaded))
+	}
+	img, err := jpeg.Decode(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replace and strip; the image must stay the same
+	icc := testSample(t, "display-p3-v2.icc")
+	var dst bytes.Buffer
+	err = EmbedICCtoJPG(&dst, bytes.NewReader(src), icc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadICCfromJPG(bytes.NewReader(dst.Bytes())); err != nil || !bytes.Equal(loaded, icc) {
+		t.Fatalf("replaced profile mismatch: %v", err)
+	}
+	var stripped bytes.Buffer
+	err = StripICCfromJPG(&stripped, bytes.NewReader(dst.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadICCfromJPG(bytes.NewReader(stripped.Bytes())); err != nil || loaded != nil {
+		t.Fatalf("profile not removed: %v", err)
+	}
+	for _, b := range [][]byte{dst.Bytes(), stripped.Bytes()} {
+		got, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, img) {
+			t.Errorf("image changed")
+		}
+	}
+}
@@ -0,0 +1,610 @@
+//
+// read the colour encoding of a JPEG XL file
+//
+// JPEG XL spec: ISO/IEC 18181-1 (codestream), ISO/IEC 18181-2 (file format)
+//
+
+package imageicc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+var (
+	jxlCodestream = []byte{0xff, 0x0a}                       // signature of a bare codestream
+	jxlSignature  = []byte("\x00\x00\x00\x0cJXL \r\n\x87\n") // the JPEG XL signature box of a container
+)
+
+// maximum size of the codestream read to decode the image header and the embedded ICC profile
+const jxlHeaderLimit = 1 << 20
+
+// JPEG XL colour spaces
+const (
+	JXLColorSpaceRGB     = 0
+	JXLColorSpaceGrey    = 1
+	JXLColorSpaceXYB     = 2
+	JXLColorSpaceUnknown = 3
+)
+
+// JPEG XL white points
+const (
+	JXLWhiteD65    = 1
+	JXLWhiteCustom = 2
+	JXLWhiteE      = 10
+	JXLWhiteDCI    = 11
+)
+
+// JPEG XL primaries
+const (
+	JXLPrimariesSRGB   = 1
+	JXLPrimariesCustom = 2
+	JXLPrimaries2100   = 9
+	JXLPrimariesP3     = 11
+)
+
+// JPEG XL transfer functions
+const (
+	JXLTransfer709     = 1
+	JXLTransferUnknown = 2
+	JXLTransferLinear  = 8
+	JXLTransferSRGB    = 13
+	JXLTransferPQ      = 16
+	JXLTransferDCI     = 17
+	JXLTransferHLG     = 18
+)
+
+// JXLColorEncoding is the colour encoding in the image header of a JPEG XL codestream.
+// If WantICC is set then the colour space is described by an embedded ICC profile
+// and the other fields except ColorSpace are not used.
+type JXLColorEncoding struct {
+	WantICC    bool
+	ColorSpace int // one of JXLColorSpace*
+
+	WhitePoint int          // one of JXLWhite*
+	White      Chromaticity // custom white point
+
+	Primaries        int          // one of JXLPrimaries*; RGB only
+	Red, Green, Blue Chromaticity // custom primaries
+
+	Gamma            float64 // display gamma, e.g. 2.2; 0 if TransferFunction is used
+	TransferFunction int     // one of JXLTransfer*
+
+	RenderingIntent RenderingIntent
+}
+
+// the default colour encoding, sRGB
+var jxlDefaultColorEncoding = JXLColorEncoding{
+	ColorSpace:       JXLColorSpaceRGB,
+	WhitePoint:       JXLWhiteD65,
+	Primaries:        JXLPrimariesSRGB,
+	TransferFunction: JXLTransferSRGB,
+	RenderingIntent:  IntentRelativeColorimetric,
+}
+
+// String returns a short description of the colour encoding, in the style of libjxl,
+// e.g. "RGB_D65_SRG_Rel_SRG".
+func (enc *JXLColorEncoding) String() string {
+	if enc.WantICC {
+		return "ICC"
+	}
+	name := func(v int, names map[int]string) string {
+		if s, ok := names[v]; ok {
+			return s
+		}
+		return fmt.Sprintf("%d", v)
+	}
+	xy := func(c Chromaticity) string {
+		return fmt.Sprintf("%.7g;%.7g", c.X, c.Y)
+	}
+	s := name(enc.ColorSpace, map[int]string{JXLColorSpaceRGB: "RGB", JXLColorSpaceGrey: "Gra", JXLColorSpaceXYB: "XYB", JXLColorSpaceUnknown: "CS?"})
+	if enc.ColorSpace == JXLColorSpaceXYB {
+		return s + "_" + name(int(enc.RenderingIntent), map[int]string{0: "Per", 1: "Rel", 2: "Sat", 3: "Abs"})
+	}
+	if enc.WhitePoint == JXLWhiteCustom {
+		s += "_" + xy(enc.White)
+	} else {
+		s += "_" + name(enc.WhitePoint, map[int]string{JXLWhiteD65: "D65", JXLWhiteE: "EER", JXLWhiteDCI: "DCI"})
+	}
+	if enc.ColorSpace == JXLColorSpaceRGB {
+		if enc.Primaries == JXLPrimariesCustom {
+			s += "_" + xy(enc.Red) + ";" + xy(enc.Green) + ";" + xy(enc.Blue)
+		} else {
+			s += "_" + name(enc.Primaries, map[int]string{JXLPrimariesSRGB: "SRG", JXLPrimaries2100: "202", JXLPrimariesP3: "DCI"})
+		}
+	}
+	s += "_" + name(int(enc.RenderingIntent), map[int]string{0: "Per", 1: "Rel", 2: "Sat", 3: "Abs"})
+	if enc.Gamma != 0 {
+		return s + fmt.Sprintf("_g%.7g", 1/enc.Gamma)
+	}
+	return s + "_" + name(enc.TransferFunction, map[int]string{
+		JXLTransfer709: "709", JXLTransferUnknown: "TF?", JXLTransferLinear: "Lin", JXLTransferSRGB: "SRG",
+		JXLTransferPQ: "PeQ", JXLTransferDCI: "DCI", JXLTransferHLG: "HLG",
+	})
+}
+
+// ICC synthesizes an ICC profile of the colour encoding.
+// Encodings of the XYB or unknown colour space, and of PQ, HLG or unknown transfer functions
+// cannot be described by a matrix/TRC profile and an error is returned.
+func (enc *JXLColorEncoding) ICC() (iccProfile []byte, err error) {
+	if enc.WantICC {
+		err = fmt.Errorf("the colour encoding is an ICC profile")
+		return
+	}
+	pb := &ProfileBuilder{Description: enc.String(), Intent: enc.RenderingIntent}
+	switch enc.ColorSpace {
+	case JXLColorSpaceRGB:
+		pb.ColorSpace = ColorSpaceRGB
+		switch enc.Primaries {
+		case JXLPrimariesSRGB:
+			pb.Primaries = standardProfiles[ProfileSRGB].primaries
+		case JXLPrimaries2100:
+			pb.Primaries = standardProfiles[ProfileRec2020].primaries
+		case JXLPrimariesP3:
+			pb.Primaries = standardProfiles[ProfileDisplayP3].primaries
+		case JXLPrimariesCustom:
+			pb.Primaries = [3]Chromaticity{enc.Red, enc.Green, enc.Blue}
+		default:
+			err = fmt.Errorf("unknown primaries %d", enc.Primaries)
+			return
+		}
+	case JXLColorSpaceGrey:
+		pb.ColorSpace = ColorSpaceGray
+	default:
+		err = fmt.Errorf("colour space %d has no ICC description", enc.ColorSpace)
+		return
+	}
+
+	switch enc.WhitePoint {
+	case JXLWhiteD65:
+		pb.White = whiteD65
+	case JXLWhiteE:
+		pb.White = Chromaticity{1.0 / 3, 1.0 / 3}
+	case JXLWhiteDCI:
+		pb.White = Chromaticity{0.314, 0.351}
+	case JXLWhiteCustom:
+		pb.White = enc.White
+	default:
+		err = fmt.Errorf("unknown white point %d", enc.WhitePoint)
+		return
+	}
+
+	var curve Curve
+	if enc.Gamma != 0 {
+		curve = GammaCurve(enc.Gamma)
+	} else {
+		switch enc.TransferFunction {
+		case JXLTransfer709:
+			curve = rec709Curve
+		case JXLTransferLinear:
+			curve = GammaCurve(1)
+		case JXLTransferSRGB:
+			curve = srgbCurve
+		case JXLTransferDCI:
+			curve = GammaCurve(2.6)
+		default:
+			err = fmt.Errorf("transfer function %d has no ICC description", enc.TransferFunction)
+			return
+		}
+	}
+	pb.Curves = []Curve{curve}
+	return pb.Build()
+}
+
+// a bit reader of a JPEG XL codestream; bits are read from the least significant bit of each byte.
+// Reading past the end sets err and returns zeros.
+type jxlBitReader struct {
+	data []byte
+	pos  int // bit position
+	err  error
+}
+
+// read n bits
+func (r *jxlBitReader) u(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		if r.pos>>3 >= len(r.data) {
+			if r.err == nil {
+				r.err = fmt.Errorf("JPEG XL header truncated")
+			}
+			return 0
+		}
+		v |= uint64(r.data[r.pos>>3]>>(r.pos&7)&1) << i
+		r.pos++
+	}
+	return v
+}
+
+// skip n bits
+func (r *jxlBitReader) skip(n uint64) {
+	if n > uint64(len(r.data)*8-r.pos) {
+		r.pos = len(r.data) * 8
+		if r.err == nil {
+			r.err = fmt.Errorf("JPEG XL header truncated")
+		}
+		return
+	}
+	r.pos += int(n)
+}
+
+func (r *jxlBitReader) bool() bool {
+	return r.u(1) != 0
+}
+
+// a distribution of U32; offset + u(bits)
+type jxlDist struct {
+	offset uint32
+	bits   int
+}
+
+// read a U32 of four distributions
+func (r *jxlBitReader) u32(d0, d1, d2, d3 jxlDist) uint32 {
+	d := [4]jxlDist{d0, d1, d2, d3}[r.u(2)]
+	return d.offset + uint32(r.u(d.bits))
+}
+
+// read a U64
+func (r *jxlBitReader) u64() uint64 {
+	switch r.u(2) {
+	case 0:
+		return 0
+	case 1:
+		return 1 + r.u(4)
+	case 2:
+		return 17 + r.u(8)
+	}
+	v := r.u(12)
+	for shift := 12; r.bool(); shift += 8 {
+		if shift == 60 {
+			return v | r.u(4)<<60
+		}
+		v |= r.u(8) << shift
+	}
+	return v
+}
+
+// read an Enum
+func (r *jxlBitReader) enum() int {
+	return int(r.u32(jxlDist{0, 0}, jxlDist{1, 0}, jxlDist{2, 4}, jxlDist{18, 6}))
+}
+
+// read a signed number in a U32 of custom xy coordinates, in millionths
+func (r *jxlBitReader) customXY() float64 {
+	v := r.u32(jxlDist{0, 19}, jxlDist{524288, 19}, jxlDist{1048576, 20}, jxlDist{2097152, 21})
+	s := int64(v >> 1)
+	if v&1 != 0 {
+		s = -s - 1
+	}
+	return float64(s) / 1e6
+}
+
+func (r *jxlBitReader) chromaticity() Chromaticity {
+	x := r.customXY()
+	return Chromaticity{x, r.customXY()}
+}
+
+// skip extensions
+func (r *jxlBitReader) extensions() {
+	ext := r.u64()
+	var total uint64
+	for ; ext != 0 && r.err == nil; ext &= ext - 1 {
+		total += r.u64()
+	}
+	r.skip(total)
+}
+
+// skip a SizeHeader
+func (r *jxlBitReader) sizeHeader() {
+	dist := [4]jxlDist{{1, 9}, {1, 13}, {1, 18}, {1, 30}}
+	div8 := r.bool()
+	if div8 {
+		r.u(5)
+	} else {
+		r.u32(dist[0], dist[1], dist[2], dist[3])
+	}
+	if r.u(3) == 0 { // ratio
+		if div8 {
+			r.u(5)
+		} else {
+			r.u32(dist[0], dist[1], dist[2], dist[3])
+		}
+	}
+}
+
+// skip a PreviewHeader
+func (r *jxlBitReader) previewHeader() {
+	div8 := r.bool()
+	size := func() {
+		if div8 {
+			r.u32(jxlDist{16, 0}, jxlDist{32, 0}, jxlDist{1, 5}, jxlDist{33, 9})
+		} else {
+			r.u32(jxlDist{1, 6}, jxlDist{65, 8}, jxlDist{321, 10}, jxlDist{1345, 12})
+		}
+	}
+	size()
+	if r.u(3) == 0 { // ratio
+		size()
+	}
+}
+
+// skip an AnimationHeader
+func (r *jxlBitReader) animationHeader() {
+	r.u32(jxlDist{100, 0}, jxlDist{1000, 0}, jxlDist{1, 10}, jxlDist{1, 30})
+	r.u32(jxlDist{1, 0}, jxlDist{1001, 0}, jxlDist{1, 8}, jxlDist{1, 10})
+	r.u32(jxlDist{0, 0}, jxlDist{0, 3}, jxlDist{0, 16}, jxlDist{0, 32})
+	r.bool() // have_timecodes
+}
+
+// skip a BitDepth
+func (r *jxlBitReader) bitDepth() {
+	if r.bool() { // float_sample
+		r.u32(jxlDist{32, 0}, jxlDist{16, 0}, jxlDist{24, 0}, jxlDist{1, 6})
+		r.u(4)
+	} else {
+		r.u32(jxlDist{8, 0}, jxlDist{10, 0}, jxlDist{12, 0}, jxlDist{1, 6})
+	}
+}
+
+// extra channel types with additional fields
+const (
+	jxlChannelAlpha      = 0
+	jxlChannelSpotColour = 2
+	jxlChannelCFA        = 5
+)
+
+// skip an ExtraChannelInfo
+func (r *jxlBitReader) extraChannelInfo() {
+	if r.bool() { // all_default
+		return
+	}
+	typ := r.enum()
+	r.bitDepth()
+	r.u32(jxlDist{0, 0}, jxlDist{3, 0}, jxlDist{4, 0}, jxlDist{1, 3})               // dim_shift
+	nameLen := r.u32(jxlDist{0, 0}, jxlDist{0, 4}, jxlDist{16, 5}, jxlDist{48, 10}) // name length in bytes
+	r.skip(uint64(nameLen) * 8)
+	switch typ {
+	case jxlChannelAlpha:
+		r.bool() // alpha_associated
+	case jxlChannelSpotColour:
+		r.u(16 * 4) // red, green, blue and solidity in F16
+	case jxlChannelCFA:
+		r.u32(jxlDist{1, 0}, jxlDist{0, 2}, jxlDist{3, 4}, jxlDist{19, 8})
+	}
+	r.extensions()
+}
+
+// skip a ToneMapping
+func (r *jxlBitReader) toneMapping() {
+	if r.bool() { // all_default
+		return
+	}
+	r.u(16 * 2) // intensity_target and min_nits in F16
+	r.bool()    // relative_to_max_display
+	r.u(16)     // linear_below in F16
+}
+
+// skip a CustomTransformData, which follows ImageMetadata
+func (r *jxlBitReader) customTransformData(xyb bool) {
+	if r.bool() { // all_default
+		return
+	}
+	if xyb && !r.bool() { // OpsinInverseMatrix all_default
+		r.skip(16 * (9 + 3 + 4)) // inverse matrix, opsin biases and quant biases in F16
+	}
+	mask := r.u(3) // custom upsampling weights of 2x, 4x and 8x
+	for i, n := range []uint64{15, 55, 210} {
+		if mask&(1<<i) != 0 {
+			r.skip(16 * n)
+		}
+	}
+}
+
+// read a ColourEncoding
+func (r *jxlBitReader) colourEncoding() *JXLColorEncoding {
+	enc := jxlDefaultColorEncoding
+	if r.bool() { // all_default
+		return &enc
+	}
+	enc.WantICC = r.bool()
+	enc.ColorSpace = r.enum()
+	if enc.WantICC {
+		return &enc
+	}
+	if enc.ColorSpace != JXLColorSpaceXYB {
+		enc.WhitePoint = r.enum()
+		if enc.WhitePoint == JXLWhiteCustom {
+			enc.White = r.chromaticity()
+		}
+	}
+	if enc.ColorSpace != JXLColorSpaceXYB && enc.ColorSpace != JXLColorSpaceGrey {
+		enc.Primaries = r.enum()
+		if enc.Primaries == JXLPrimariesCustom {
+			enc.Red = r.chromaticity()
+			enc.Green = r.chromaticity()
+			enc.Blue = r.chromaticity()
+		}
+	}
+	if enc.ColorSpace == JXLColorSpaceXYB {
+		// the transfer function of XYB is implicit
+		enc.Gamma = 3
+	} else if r.bool() { // have_gamma
+		g := r.u(24) // encoding gamma in 1e-7 units, the inverse of the display gamma
+		if g == 0 {
+			r.err = fmt.Errorf("invalid JPEG XL gamma")
+			return nil
+		}
+		enc.Gamma = 1e7 / float64(g)
+	} else {
+		enc.TransferFunction = r.enum()
+	}
+	enc.RenderingIntent = RenderingIntent(r.enum())
+	return &enc
+}
+
+// decode the colour encoding in the image header of a codestream.
+// If enc.WantICC is set then the embedded ICC profile following the header is decoded to iccProfile.
+func decodeJXLColorEncoding(codestream []byte) (enc *JXLColorEncoding, iccProfile []byte, err error) {
+	if !bytes.HasPrefix(codestream, jxlCodestream) {
+		err = fmt.Errorf("invalid JPEG XL codestream signature")
+		return
+	}
+	r := &jxlBitReader{data: codestream[len(jxlCodestream):]}
+	r.sizeHeader()
+
+	// ImageMetadata
+	xyb := false
+	if r.bool() { // all_default
+		e := jxlDefaultColorEncoding
+		enc = &e
+	} else {
+		extraFields := r.bool()
+		if extraFields {
+			r.u(3) // orientation
+			if r.bool() {
+				r.sizeHeader() // intrinsic size
+			}
+			if r.bool() {
+				r.previewHeader()
+			}
+			if r.bool() {
+				r.animationHeader()
+			}
+		}
+		r.bitDepth()
+		r.bool() // modular_16bit_buffers
+		n := r.u32(jxlDist{0, 0}, jxlDist{1, 0}, jxlDist{2, 4}, jxlDist{1, 12})
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			r.extraChannelInfo()
+		}
+		xyb = r.bool()
+		enc = r.colourEncoding()
+		if enc != nil && enc.WantICC {
+			if extraFields {
+				r.toneMapping()
+			}
+			r.extensions()
+		}
+	}
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if enc.RenderingIntent < IntentPerceptual || enc.RenderingIntent > IntentAbsoluteColorimetric {
+		err = fmt.Errorf("invalid JPEG XL rendering intent %d", int(enc.RenderingIntent))
+		return nil, nil, err
+	}
+	if enc.Gamma != 0 && (math.IsInf(enc.Gamma, 0) || enc.Gamma < 0) {
+		err = fmt.Errorf("invalid JPEG XL gamma")
+		return nil, nil, err
+	}
+
+	// the ICC stream follows the transform data
+	if enc.WantICC {
+		r.customTransformData(xyb)
+		iccProfile, err = r.iccStream()
+		if err != nil {
+			if r.pos >= len(r.data)*8 && len(codestream) >= jxlHeaderLimit {
+				// ran out of the data read
+				err = fmt.Errorf("JPEG XL ICC stream exceeds the %d byte limit", jxlHeaderLimit)
+			}
+			return nil, nil, err
+		}
+	}
+	return
+}
+
+// read the head of the codestream in a bare codestream or a container
+func readJXLCodestreamHead(in io.ReadSeeker) (codestream []byte, err error) {
+	h := make([]byte, len(jxlSignature))
+	n, err := io.ReadFull(in, h)
+	if err == io.ErrUnexpectedEOF && bytes.HasPrefix(h[:n], jxlCodestream) {
+		return h[:n], nil
+	}
+	if err != nil {
+		return
+	}
+
+	if bytes.HasPrefix(h, jxlCodestream) {
+		codestream = make([]byte, jxlHeaderLimit)
+		copy(codestream, h)
+		n, err = io.ReadFull(in, codestream[len(h):])
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = nil
+		}
+		return codestream[:len(h)+n], err
+	}
+	if !bytes.Equal(h, jxlSignature) {
+		err = fmt.Errorf("invalid JPEG XL signature")
+		return
+	}
+
+	// the codestream is in a 'jxlc' box, or split into 'jxlp' boxes
+	boxes, err := readTopISOBoxes(in)
+	if err != nil {
+		return
+	}
+	for _, box := range boxes {
+		skip := int64(0)
+		switch box.Type {
+		case "jxlc":
+		case "jxlp":
+			skip = 4 // index of the part
+		default:
+			continue
+		}
+		if box.Size < skip {
+			err = fmt.Errorf("jxlp box too short")
+			return
+		}
+		box.Offset += skip
+		box.Size -= skip
+		if rest := int64(jxlHeaderLimit - len(codestream)); box.Size > rest {
+			box.Size = rest
+		}
+		var data []byte
+		data, err = readISOBoxData(in, box)
+		if err != nil {
+			return
+		}
+		codestream = append(codestream, data...)
+		if len(codestream) >= jxlHeaderLimit {
+			break
+		}
+	}
+	if codestream == nil {
+		err = fmt.Errorf("JPEG XL codestream not found")
+	}
+	return
+}
+
+// Read ICC profile of a JPEG XL file, either a bare codestream or a container.
+// If the image header has an enumerated colour encoding then a profile synthesized from it is returned;
+// if the encoding cannot be described by an ICC profile, e.g. of the PQ transfer function, then nil data and no error is returned.
+// If the codestream has an embedded ICC profile then the decoded profile is returned;
+// an error is returned if the profile does not end within the first 1 MiB of the codestream.
+func LoadICCfromJXL(in io.ReadSeeker) (iccProfile []byte, err error) {
+	iccProfile, _, err = LoadColorFromJXL(in)
+	return
+}
+
+// Read the colour encoding of a JPEG XL file, either a bare codestream or a container.
+// If the encoding is enumerated then iccProfile is a profile synthesized from it,
+// or nil if the encoding cannot be described by an ICC profile.
+// If enc.WantICC is set then iccProfile is the ICC profile embedded in the codestream.
+func LoadColorFromJXL(in io.ReadSeeker) (iccProfile []byte, enc *JXLColorEncoding, err error) {
+	codestream, err := readJXLCodestreamHead(in)
+	if err != nil {
+		return
+	}
+	enc, iccProfile, err = decodeJXLColorEncoding(codestream)
+	if err != nil || enc.WantICC {
+		return
+	}
+	iccProfile, synthErr := enc.ICC()
+	if synthErr != nil {
+		// not describable by an ICC profile
+		iccProfile = nil
+	}
+	return
+}
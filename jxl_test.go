@@ -0,0 +1,845 @@
+package imageicc
+
+import (
+	"bytes"
+	"math/bits"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// a bit writer of JPEG XL codestreams, from the least significant bit
+type testBitWriter struct {
+	b   []byte
+	pos int
+}
+
+func (w *testBitWriter) u(n int, v uint64) *testBitWriter {
+	for i := 0; i < n; i++ {
+		if w.pos&7 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>i&1) << (w.pos & 7)
+		w.pos++
+	}
+	return w
+}
+
+func (w *testBitWriter) bool(v bool) *testBitWriter {
+	if v {
+		return w.u(1, 1)
+	}
+	return w.u(1, 0)
+}
+
+// write an Enum
+func (w *testBitWriter) enum(v int) *testBitWriter {
+	switch {
+	case v < 2:
+		return w.u(2, uint64(v))
+	case v < 18:
+		return w.u(2, 2).u(4, uint64(v-2))
+	}
+	return w.u(2, 3).u(6, uint64(v-18))
+}
+
+// a bare codestream of a 64x64 image with a header written by meta
+func testJXL(meta func(w *testBitWriter)) []byte {
+	w := &testBitWriter{}
+	w.bool(true).u(5, 7).u(3, 1) // SizeHeader: div8, height 64, ratio 1:1
+	meta(w)
+	w.u(64, 0) // following data
+	return append([]byte{0xff, 0x0a}, w.b...)
+}
+
+// ImageMetadata with extra fields, an extra channel and a colour encoding written by colour
+func testJXLMetadata(colour func(w *testBitWriter)) func(w *testBitWriter) {
+	return func(w *testBitWriter) {
+		w.bool(false)                     // all_default
+		w.bool(true)                      // extra_fields
+		w.u(3, 0)                         // orientation
+		w.bool(true)                      // have_intrinsic_size
+		w.bool(false).u(2, 1).u(13, 999)  // SizeHeader: height 1000
+		w.u(3, 0).u(2, 0).u(9, 99)        // ratio 0, width 100
+		w.bool(true)                      // have_preview
+		w.bool(false).u(2, 0).u(6, 31)    // PreviewHeader: height 32
+		w.u(3, 1)                         // ratio 1:1
+		w.bool(false)                     // have_animation
+		w.bool(false).u(2, 1)             // BitDepth: integer, 10 bits
+		w.bool(true)                      // modular_16bit_buffers
+		w.u(2, 1)                         // an extra channel
+		w.bool(false).enum(2)             // spot colour channel
+		w.bool(false).u(2, 0)             // BitDepth: 8 bits
+		w.u(2, 0)                         // dim_shift
+		w.u(2, 1).u(4, 3).u(24, 0x746f70) // name "pot"
+		w.u(64, 0x3c003c003c003c00)       // spot colour in F16
+		w.u(2, 1).u(4, 0).u(2, 1).u(4, 4) // extensions 1 of 5 bits
+		w.u(5, 0)
+		w.bool(true) // xyb_encoded
+		colour(w)
+	}
+}
+
+// write a U64
+func (w *testBitWriter) u64(v uint64) *testBitWriter {
+	switch {
+	case v == 0:
+		return w.u(2, 0)
+	case v <= 16:
+		return w.u(2, 1).u(4, v-1)
+	case v <= 272:
+		return w.u(2, 2).u(8, v-17)
+	}
+	w.u(2, 3).u(12, v&0xfff)
+	for v >>= 12; v != 0; v >>= 8 {
+		w.bool(true).u(8, v&0xff)
+	}
+	return w.bool(false)
+}
+
+// write a number of 0 to 255, or 0 to 65535 if wide
+func (w *testBitWriter) varLenUint(v int, wide bool) *testBitWriter {
+	if v == 0 {
+		return w.bool(false)
+	}
+	n := bits.Len(uint(v)) - 1
+	w.bool(true)
+	if wide {
+		w.u(4, uint64(n))
+	} else {
+		w.u(3, uint64(n))
+	}
+	return w.u(n, uint64(v-1<<n))
+}
+
+func (w *testBitWriter) uintConfig(c jxlUintConfig, logAlphaSize int) *testBitWriter {
+	w.u(jxlCeilLog2(uint32(logAlphaSize)+1), uint64(c.splitExponent))
+	if c.splitExponent != uint32(logAlphaSize) {
+		w.u(jxlCeilLog2(c.splitExponent+1), uint64(c.msbInToken))
+		w.u(jxlCeilLog2(c.splitExponent-c.msbInToken+1), uint64(c.lsbInToken))
+	}
+	return w
+}
+
+// a token of an entropy-coded stream with its raw bits
+type testJXLToken struct {
+	cluster int
+	token   uint32
+	n       int
+	raw     uint64
+}
+
+// split a value into a hybrid integer token and raw bits
+func testHybridUint(cluster int, c jxlUintConfig, v uint32) testJXLToken {
+	split := uint32(1) << c.splitExponent
+	if v < split {
+		return testJXLToken{cluster, v, 0, 0}
+	}
+	n := uint32(bits.Len32(v)) - 1
+	m := v - 1<<n
+	token := split + (n-c.splitExponent)<<(c.msbInToken+c.lsbInToken) +
+		(m>>(n-c.msbInToken))<<c.lsbInToken + m&(1<<c.lsbInToken-1)
+	nbits := n - c.msbInToken - c.lsbInToken
+	return testJXLToken{cluster, token, int(nbits), uint64(v>>c.lsbInToken) & (1<<nbits - 1)}
+}
+
+// tokens of the bytes of an ICC stream, with greedy LZ77 copies if lz77 is given
+func testJXLICCTokens(enc []byte, contextMap []int, configs []jxlUintConfig, lz77 *jxlLZ77) (tokens []testJXLToken) {
+	for i := 0; i < len(enc); {
+		var b1, b2 byte
+		if i > 0 {
+			b1 = enc[i-1]
+		}
+		if i > 1 {
+			b2 = enc[i-2]
+		}
+		cluster := contextMap[jxlICCContext(i, b1, b2)]
+		if lz77 != nil {
+			length, dist := 0, 0
+			for d := 1; d <= i; d++ {
+				l := 0
+				for i+l < len(enc) && enc[i+l] == enc[i+l-d] {
+					l++
+				}
+				if l > length {
+					length, dist = l, d
+				}
+			}
+			if length >= 8 {
+				t := testHybridUint(cluster, lz77.lengthConf, uint32(length)-lz77.minLength)
+				t.token += lz77.minSymbol
+				distCluster := contextMap[len(contextMap)-1]
+				tokens = append(tokens, t, testHybridUint(distCluster, configs[distCluster], uint32(dist-1)))
+				i += length
+				continue
+			}
+		}
+		tokens = append(tokens, testHybridUint(cluster, configs[cluster], uint32(enc[i])))
+		i++
+	}
+	return
+}
+
+// the frequencies of tokens of each cluster
+func testTokenFreqs(tokens []testJXLToken, numClusters int) [][]int {
+	freqs := make([][]int, numClusters)
+	for _, t := range tokens {
+		for len(freqs[t.cluster]) <= int(t.token) {
+			freqs[t.cluster] = append(freqs[t.cluster], 0)
+		}
+		freqs[t.cluster][t.token]++
+	}
+	return freqs
+}
+
+// complete code lengths of used symbols; the lowest ones are shorter
+func testFlatLengths(freq []int) []uint8 {
+	k := 0
+	for _, f := range freq {
+		if f > 0 {
+			k++
+		}
+	}
+	l := bits.Len(uint(k - 1))
+	short := 1<<l - k
+	lengths := make([]uint8, len(freq))
+	for s, f := range freq {
+		if f == 0 {
+			continue
+		}
+		lengths[s] = uint8(l)
+		if short > 0 {
+			lengths[s]--
+			short--
+		}
+		if lengths[s] == 0 {
+			lengths[s] = 1 // a single symbol
+		}
+	}
+	return lengths
+}
+
+// write a symbol of a canonical prefix code
+func (w *testBitWriter) prefixSymbol(lengths []uint8, s int) *testBitWriter {
+	nonzero := 0
+	for _, l := range lengths {
+		if l != 0 {
+			nonzero++
+		}
+	}
+	if nonzero == 1 {
+		return w
+	}
+	code := 0
+	for l := uint8(1); l <= jxlPrefixMaxBits; l++ {
+		for t, tl := range lengths {
+			if tl != l {
+				continue
+			}
+			if t == s {
+				for b := int(l) - 1; b >= 0; b-- {
+					w.u(1, uint64(code>>b&1))
+				}
+				return w
+			}
+			code++
+		}
+		code <<= 1
+	}
+	panic("symbol not in the prefix code")
+}
+
+// write a prefix code of tokens with the frequencies, returning its code lengths.
+// Codes of up to 4 symbols are simple; others have zero runs.
+func (w *testBitWriter) prefixCode(freq []int) []uint8 {
+	lengths := testFlatLengths(freq)
+	if len(freq) == 1 {
+		return lengths
+	}
+	var symbols []int
+	for s, f := range freq {
+		if f > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) <= 4 {
+		w.u(2, 1).u(2, uint64(len(symbols)-1))
+		for _, s := range symbols {
+			w.u(bits.Len(uint(len(freq)-1)), uint64(s))
+		}
+		if len(symbols) == 4 {
+			w.bool(false) // tree-select
+		}
+		return lengths
+	}
+
+	// code length symbols
+	type clSymbol struct{ v, n int }
+	var cls []clSymbol
+	clFreq := make([]int, 18)
+	for s := 0; s < len(lengths); {
+		run := 0
+		for s+run < len(lengths) && lengths[s+run] == 0 {
+			run++
+		}
+		if run >= 3 {
+			if run > 10 {
+				run = 10
+			}
+			cls = append(cls, clSymbol{17, run})
+			clFreq[17]++
+			s += run
+			if s < len(lengths) && lengths[s] == 0 {
+				cls = append(cls, clSymbol{0, 1})
+				clFreq[0]++
+				s++
+			}
+			continue
+		}
+		cls = append(cls, clSymbol{int(lengths[s]), 1})
+		clFreq[lengths[s]]++
+		s++
+	}
+	clLengths := testFlatLengths(clFreq)
+
+	w.u(2, 0) // no skip
+	static := [6]struct {
+		n int
+		v uint64
+	}{{2, 0}, {4, 7}, {3, 3}, {2, 2}, {2, 1}, {4, 0xf}}
+	space := 32
+	for i := 0; i < 18 && space > 0; i++ {
+		v := clLengths[jxlCodeLengthOrder[i]]
+		w.u(static[v].n, static[v].v)
+		if v != 0 {
+			space -= 32 >> v
+		}
+	}
+	for _, c := range cls {
+		w.prefixSymbol(clLengths, c.v)
+		if c.v == 17 {
+			w.u(3, uint64(c.n-3))
+		}
+	}
+	return lengths
+}
+
+// normalize frequencies to counts of an ANS distribution
+func testANSCounts(freq []int) []int {
+	total, most := 0, 0
+	for s, f := range freq {
+		total += f
+		if f > freq[most] {
+			most = s
+		}
+	}
+	counts := make([]int, len(freq))
+	sum := 0
+	for s, f := range freq {
+		if f > 0 {
+			counts[s] = f * jxlANSTabSize / total
+			if counts[s] == 0 {
+				counts[s] = 1
+			}
+			sum += counts[s]
+		}
+	}
+	counts[most] += jxlANSTabSize - sum
+	return counts
+}
+
+// write an ANS distribution, with runs of equal counts
+func (w *testBitWriter) ansDistribution(counts []int) {
+	var symbols []int
+	for s, c := range counts {
+		if c > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	switch len(symbols) {
+	case 1:
+		w.bool(true).u(1, 0).varLenUint(symbols[0], false)
+		return
+	case 2:
+		w.bool(true).u(1, 1).varLenUint(symbols[0], false).varLenUint(symbols[1], false)
+		w.u(jxlANSLogTabSize, uint64(counts[symbols[0]]))
+		return
+	}
+	for len(counts) < 3 {
+		counts = append(counts, 0)
+	}
+	w.bool(false).bool(false)          // not simple nor flat
+	w.u(3, 7).u(3, 6)                  // shift 13, full precision
+	w.varLenUint(len(counts)-3, false) // length
+
+	logCounts := make([]int, len(counts))
+	omit := 0
+	for i, c := range counts {
+		logCounts[i] = bits.Len(uint(c))
+		if logCounts[i] > logCounts[omit] {
+			omit = i
+		}
+	}
+	static := [14]struct {
+		n int
+		v uint64
+	}{{5, 17}, {4, 11}, {4, 15}, {4, 3}, {4, 9}, {4, 7}, {3, 4}, {3, 2}, {3, 5}, {3, 6}, {3, 0}, {6, 33}, {7, 1}, {7, 65}}
+	inRun := make([]bool, len(counts))
+	for i := 0; i < len(counts); {
+		run := 0
+		if i > 0 && i-1 != omit {
+			for i+run < len(counts) && i+run != omit && counts[i+run] == counts[i-1] && run < 259 {
+				run++
+			}
+		}
+		if run >= 4 {
+			w.u(static[13].n, static[13].v).varLenUint(run-4, false)
+			for j := i; j < i+run; j++ {
+				inRun[j] = true
+			}
+			i += run
+			continue
+		}
+		w.u(static[logCounts[i]].n, static[logCounts[i]].v)
+		i++
+	}
+	for i, c := range counts {
+		if !inRun[i] && i != omit && logCounts[i] > 1 {
+			w.u(logCounts[i]-1, uint64(c-1<<(logCounts[i]-1)))
+		}
+	}
+}
+
+// write tokens by ANS; encoded in reverse from the final state
+func (w *testBitWriter) ansTokens(tokens []testJXLToken, counts [][]int, logAlphaSize int) {
+	logEntrySize := jxlANSLogTabSize - logAlphaSize
+	reverse := make([]map[[2]int]uint32, len(counts))
+	for c := range counts {
+		reverse[c] = make(map[[2]int]uint32)
+		table := newJXLAliasTable(counts[c], logAlphaSize)
+		for x := 0; x < jxlANSTabSize; x++ {
+			e := table[x>>logEntrySize]
+			pos := x & (1<<logEntrySize - 1)
+			symbol, offset := x>>logEntrySize, pos
+			if pos >= e.cutoff {
+				symbol, offset = e.rightValue, e.offset1+pos
+			}
+			reverse[c][[2]int{symbol, offset}] = uint32(x)
+		}
+	}
+	chunks := make([]int, len(tokens))
+	state := uint32(jxlANSSignature)
+	for i := len(tokens) - 1; i >= 0; i-- {
+		t := tokens[i]
+		f := uint32(counts[t.cluster][t.token])
+		chunks[i] = -1
+		if state>>(32-jxlANSLogTabSize) >= f {
+			chunks[i] = int(state & 0xffff)
+			state >>= 16
+		}
+		state = (state/f)<<jxlANSLogTabSize + reverse[t.cluster][[2]int{int(t.token), int(state % f)}]
+	}
+	w.u(32, uint64(state))
+	for i, t := range tokens {
+		if chunks[i] >= 0 {
+			w.u(16, uint64(chunks[i]))
+		}
+		w.u(t.n, t.raw)
+	}
+}
+
+// shuffle bytes so that the decoder de-interleaves them by a width
+func testJXLICCInterleave(b []byte, width int) []byte {
+	height := (len(b) + width - 1) / width
+	out := make([]byte, len(b))
+	s, j := 0, 0
+	for i := range b {
+		out[j] = b[i]
+		j += height
+		if j >= len(b) {
+			s++
+			j = s
+		}
+	}
+	return out
+}
+
+// encode an ICC profile to the ICC stream before entropy coding
+func testPredictJXLICC(icc []byte) []byte {
+	be := bst.BigEndian
+	varint := func(b []byte, v int) []byte {
+		for ; v >= 128; v >>= 7 {
+			b = append(b, byte(v)|128)
+		}
+		return append(b, byte(v))
+	}
+	var commands, data []byte
+
+	header := jxlICCHeaderPrediction(uint64(len(icc)))
+	for i := 0; i < jxlICCHeaderSize; i++ {
+		jxlICCPredictHeader(icc[:i], header, i)
+		data = append(data, icc[i]-header[i])
+	}
+
+	// tag table
+	n := int(be.Uint32(icc[128:]))
+	commands = varint(commands, n+1)
+	type tagEntry struct{ start, size int }
+	var tags []tagEntry
+	prevStart, prevSize := jxlICCHeaderSize+12*n, 0
+	for i := 0; i < n; i++ {
+		e := icc[132+12*i:]
+		tag, start, size := string(e[:4]), int(be.Uint32(e[4:])), int(be.Uint32(e[8:]))
+		tags = append(tags, tagEntry{start, size})
+		code := jxlICCTagUnknown
+		for j, s := range jxlICCTagStrings {
+			if s == tag {
+				code = jxlICCTagStringFirst + j
+			}
+		}
+		if i+2 < n {
+			g, b := icc[132+12*(i+1):], icc[132+12*(i+2):]
+			switch {
+			case tag == "rTRC" && string(g[:4]) == "gTRC" && string(b[:4]) == "bTRC" &&
+				bytes.Equal(g[4:12], e[4:12]) && bytes.Equal(b[4:12], e[4:12]):
+				code = jxlICCTagTRC
+				i += 2
+			case tag == "rXYZ" && string(g[:4]) == "gXYZ" && string(b[:4]) == "bXYZ" &&
+				int(be.Uint32(g[4:])) == start+size && int(be.Uint32(b[4:])) == start+2*size &&
+				int(be.Uint32(g[8:])) == size && int(be.Uint32(b[8:])) == size:
+				code = jxlICCTagXYZ
+				tags = append(tags, tagEntry{start + size, size}, tagEntry{start + 2*size, size})
+				i += 2
+			}
+		}
+		predSize := prevSize
+		switch tag {
+		case "rXYZ", "gXYZ", "bXYZ", "kXYZ", "wtpt", "bkpt", "lumi":
+			predSize = 20
+		}
+		command := byte(code)
+		if start != prevStart+prevSize {
+			command |= jxlICCFlagOffset
+		}
+		if size != predSize {
+			command |= jxlICCFlagSize
+		}
+		commands = append(commands, command)
+		if code == jxlICCTagUnknown {
+			data = append(data, tag...)
+		}
+		if command&jxlICCFlagOffset != 0 {
+			commands = varint(commands, start)
+		}
+		if command&jxlICCFlagSize != 0 {
+			commands = varint(commands, size)
+		}
+		prevStart, prevSize = start, size
+	}
+	commands = append(commands, jxlICCTagEnd)
+
+	// tag data by the types of tags
+	add := func(command byte, b []byte) {
+		commands = varint(append(commands, command), len(b))
+		data = append(data, b...)
+	}
+	pos := jxlICCHeaderSize + 4 + 12*n
+	for _, t := range tags {
+		if t.start < pos {
+			continue // shared
+		}
+		if t.start > pos {
+			add(jxlICCInsert, icc[pos:t.start])
+		}
+		b := icc[t.start : t.start+t.size]
+		pos = t.start + t.size
+		typ := -1
+		for i, s := range jxlICCTypeStrings {
+			if s == string(b[:4]) && be.Uint32(b[4:]) == 0 {
+				typ = i
+			}
+		}
+		switch {
+		case typ == 0 && t.size == 20:
+			commands = append(commands, jxlICCXYZ)
+			data = append(data, b[8:]...)
+			continue
+		case typ < 0:
+			add(jxlICCInsert, b)
+			continue
+		}
+		commands = append(commands, byte(jxlICCTypeStart+typ))
+		switch jxlICCTypeStrings[typ] {
+		case "curv":
+			// entries predicted linearly from the previous ones
+			add(jxlICCInsert, b[8:12])
+			start := t.start + 12
+			residuals := make([]byte, t.size-12)
+			for i := range residuals {
+				residuals[i] = icc[start+i] - jxlICCLinearPredict(icc, start, i, 2, 2, 1)
+			}
+			commands = append(commands, jxlICCPredict, 1|1<<2|16) // width 2, order 1, with a stride
+			commands = varint(commands, 2)
+			commands = varint(commands, len(residuals))
+			data = append(data, testJXLICCInterleave(residuals, 2)...)
+		case "sf32":
+			add(jxlICCShuffle4, testJXLICCInterleave(b[8:], 4))
+		case "para":
+			add(jxlICCShuffle2, testJXLICCInterleave(b[8:], 2))
+		default:
+			add(jxlICCInsert, b[8:])
+		}
+	}
+	if pos < len(icc) {
+		add(jxlICCInsert, icc[pos:])
+	}
+
+	enc := varint(nil, len(icc))
+	enc = varint(enc, len(commands))
+	enc = append(enc, commands...)
+	return append(enc, data...)
+}
+
+// write the ICC stream of a profile, by prefix codes or by ANS with LZ77
+func (w *testBitWriter) jxlICC(icc []byte, ans bool) *testBitWriter {
+	enc := testPredictJXLICC(icc)
+	w.u64(uint64(len(enc)))
+
+	// the header in a distribution and the rest in another
+	contextMap := make([]int, jxlICCContexts)
+	for i := 1; i < len(contextMap); i++ {
+		contextMap[i] = 1
+	}
+	if !ans {
+		configs := []jxlUintConfig{{15, 0, 0}, {4, 2, 0}}
+		w.bool(false)        // no LZ77
+		w.bool(true).u(2, 1) // simple context map of 1 bit
+		for _, c := range contextMap {
+			w.u(1, uint64(c))
+		}
+		w.bool(true) // prefix codes
+		for _, c := range configs {
+			w.uintConfig(c, jxlPrefixMaxBits)
+		}
+		tokens := testJXLICCTokens(enc, contextMap, configs, nil)
+		freqs := testTokenFreqs(tokens, len(configs))
+		for _, f := range freqs {
+			w.varLenUint(len(f)-1, true)
+		}
+		lengths := make([][]uint8, len(freqs))
+		for c, f := range freqs {
+			lengths[c] = w.prefixCode(f)
+		}
+		for _, t := range tokens {
+			w.prefixSymbol(lengths[t.cluster], int(t.token)).u(t.n, t.raw)
+		}
+		return w
+	}
+
+	// LZ77 distances in a third distribution
+	contextMap = append(contextMap, 2)
+	configs := []jxlUintConfig{{4, 1, 1}, {4, 2, 1}, {4, 1, 0}}
+	lz77 := &jxlLZ77{enabled: true, minSymbol: 224, minLength: 3, lengthConf: jxlUintConfig{4, 1, 0}}
+	w.bool(true).u(2, 0).u(2, 0) // LZ77 of min_symbol 224 and min_length 3
+	w.uintConfig(lz77.lengthConf, 8)
+
+	// context map entropy-coded after move-to-front
+	w.bool(false).bool(true)
+	var mtf []int
+	order := []int{0, 1, 2}
+	for _, c := range contextMap {
+		i := 0
+		for order[i] != c {
+			i++
+		}
+		mtf = append(mtf, i)
+		copy(order[1:i+1], order[:i])
+		order[0] = c
+	}
+	mtfFreq := make([]int, 3)
+	for _, v := range mtf {
+		mtfFreq[v]++
+	}
+	w.bool(false).bool(true).u(4, 15) // no LZ77, prefix code, hybrid integers of tokens as is
+	w.varLenUint(len(mtfFreq)-1, true)
+	mtfLengths := w.prefixCode(mtfFreq)
+	for _, v := range mtf {
+		w.prefixSymbol(mtfLengths, v)
+	}
+
+	w.bool(false).u(2, 3) // ANS of log_alpha_size 8
+	for _, c := range configs {
+		w.uintConfig(c, 8)
+	}
+	tokens := testJXLICCTokens(enc, contextMap, configs, lz77)
+	freqs := testTokenFreqs(tokens, len(configs))
+	counts := make([][]int, len(freqs))
+	for c, f := range freqs {
+		counts[c] = testANSCounts(f)
+		w.ansDistribution(counts[c])
+	}
+	w.ansTokens(tokens, counts, 8)
+	return w
+}
+
+func TestLoadICCfromJXL(t *testing.T) {
+	// default metadata
+	b := testJXL(func(w *testBitWriter) { w.bool(true) })
+	icc, enc, err := LoadColorFromJXL(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc.String() != "RGB_D65_SRG_Rel_SRG" {
+		t.Errorf("default encoding %v", enc)
+	}
+	if ok, err := IsSRGB(icc); !ok || err != nil {
+		t.Errorf("default encoding is not sRGB: %v", err)
+	}
+
+	tests := []struct {
+		colour   func(w *testBitWriter)
+		desc     string
+		standard StandardProfile
+	}{
+		// Display P3
+		{func(w *testBitWriter) {
+			w.bool(false).bool(false).enum(JXLColorSpaceRGB).enum(JXLWhiteD65).enum(JXLPrimariesP3)
+			w.bool(false).enum(JXLTransferSRGB).enum(0)
+		}, "RGB_D65_DCI_Per_SRG", ProfileDisplayP3},
+		// gray gamma 2.2
+		{func(w *testBitWriter) {
+			w.bool(false).bool(false).enum(JXLColorSpaceGrey).enum(JXLWhiteD65)
+			w.bool(true).u(24, 4545455).enum(1)
+		}, "Gra_D65_Rel_g0.4545455", ProfileGray22},
+		// custom sRGB primaries
+		{func(w *testBitWriter) {
+			xy := func(v int) { // non-negative, in millionths
+				u := uint64(v * 2)
+				switch {
+				case u < 524288:
+					w.u(2, 0).u(19, u)
+				case u < 1048576:
+					w.u(2, 1).u(19, u-524288)
+				default:
+					w.u(2, 2).u(20, u-1048576)
+				}
+			}
+			w.bool(false).bool(false).enum(JXLColorSpaceRGB).enum(JXLWhiteCustom)
+			xy(312700)
+			xy(329000)
+			w.enum(JXLPrimariesCustom)
+			for _, v := range []int{640000, 330000, 300000, 600000, 150000, 60000} {
+				xy(v)
+			}
+			w.bool(false).enum(JXLTransferSRGB).enum(1)
+		}, "RGB_0.3127;0.329_0.64;0.33;0.3;0.6;0.15;0.06_Rel_SRG", ProfileSRGB},
+	}
+	for _, tc := range tests {
+		b := testJXL(testJXLMetadata(tc.colour))
+		icc, enc, err := LoadColorFromJXL(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		if enc.String() != tc.desc {
+			t.Errorf("encoding %v, want %s", enc, tc.desc)
+		}
+		known, err := IdentifyProfile(icc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if known == nil || known.Standard != tc.standard {
+			t.Errorf("%s: synthesized profile identified as %+v", tc.desc, known)
+		}
+	}
+
+	// PQ has no ICC description
+	b = testJXL(testJXLMetadata(func(w *testBitWriter) {
+		w.bool(false).bool(false).enum(JXLColorSpaceRGB).enum(JXLWhiteD65).enum(JXLPrimaries2100)
+		w.bool(false).enum(JXLTransferPQ).enum(0)
+	}))
+	icc, err = LoadICCfromJXL(bytes.NewReader(b))
+	if err != nil || icc != nil {
+		t.Errorf("PQ: %d bytes, %v", len(icc), err)
+	}
+
+	// embedded ICC profiles, by prefix codes and by ANS
+	for _, version := range []int{2, 4} {
+		want, err := StandardICC(ProfileSRGB, version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = testJXL(testJXLMetadata(func(w *testBitWriter) {
+			w.bool(false).bool(true).enum(JXLColorSpaceRGB)
+			w.bool(false).u(16, 0x5c00).u(16, 0).bool(false).u(16, 0) // ToneMapping
+			w.u(2, 0)                                                 // no extensions
+			w.bool(false).bool(false).u(16*16, 0).u(3, 1).u(16*15, 0) // custom transform data
+			w.jxlICC(want, version == 4)
+		}))
+		if version == 4 {
+			// in a container split into parts
+			c := append([]byte(nil), jxlSignature...)
+			c = append(c, testBox("ftyp", []byte("jxl \x00\x00\x00\x00jxl "))...)
+			c = append(c, testBox("jxlp", []byte{0, 0, 0, 0}, b[:5])...)
+			c = append(c, testBox("Exif", make([]byte, 10))...)
+			c = append(c, testBox("jxlp", []byte{0x80, 0, 0, 1}, b[5:])...)
+			b = c
+		}
+		icc, enc, err = LoadColorFromJXL(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("v%d: %v", version, err)
+		}
+		if !enc.WantICC || enc.ColorSpace != JXLColorSpaceRGB {
+			t.Errorf("v%d: encoding %+v", version, enc)
+		}
+		if !bytes.Equal(icc, want) {
+			t.Errorf("v%d: embedded profile of %d bytes differs", version, len(icc))
+		}
+		if icc, format, err := LoadICC(bytes.NewReader(b)); format != FormatJXL || err != nil || !bytes.Equal(icc, want) {
+			t.Errorf("v%d: LoadICC %v, %v", version, format, err)
+		}
+		if _, err := LoadICCfromJXL(bytes.NewReader(b[:len(b)-len(want)/4])); err == nil {
+			t.Errorf("v%d: truncated ICC stream accepted", version)
+		}
+	}
+
+	// an ICC stream beyond the read limit
+	b = testJXL(testJXLMetadata(func(w *testBitWriter) {
+		w.bool(false).bool(true).enum(JXLColorSpaceRGB)
+		w.bool(false).u(16, 0x5c00).u(16, 0).bool(false).u(16, 0) // ToneMapping
+		w.u(2, 0)                                                 // no extensions
+		w.bool(false).bool(false).u(16*16, 0).u(3, 1).u(16*15, 0) // custom transform data
+		w.jxlICC(testProfileWithTags([]testTag{{"zzzz", testProfile(jxlHeaderLimit, 1)}}), false)
+	}))
+	if _, err := LoadICCfromJXL(bytes.NewReader(b)); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("ICC stream beyond the limit: %v", err)
+	}
+
+	// truncated
+	if _, _, err := LoadColorFromJXL(bytes.NewReader(b[:6])); err == nil {
+		t.Errorf("truncated header accepted")
+	}
+}
+
+func TestCjxlSamples(t *testing.T) {
+	// files written by cjxl, each with the source profile in a .icc file of the same name
+	names, err := filepath.Glob(filepath.Join("_testdata", "cjxl-*.jxl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Skip("no cjxl samples in _testdata")
+	}
+	for _, name := range names {
+		name = filepath.Base(name)
+		want := testSample(t, strings.TrimSuffix(name, ".jxl")+".icc")
+		icc, enc, err := LoadColorFromJXL(bytes.NewReader(testSample(t, name)))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !enc.WantICC || !bytes.Equal(icc, want) {
+			t.Errorf("%s: profile of %d bytes differs from the source profile", name, len(icc))
+		}
+	}
+}
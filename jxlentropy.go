@@ -0,0 +1,765 @@
+//
+// decode entropy-coded streams of a JPEG XL codestream
+//   prefix codes, ANS, hybrid integers, LZ77 and context maps
+//
+// JPEG XL spec: ISO/IEC 18181-1
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+const (
+	jxlANSLogTabSize = 12         // precision of ANS distributions
+	jxlANSTabSize    = 1 << 12    // sum of an ANS distribution
+	jxlANSSignature  = 0x13 << 16 // initial state of prefix codes and final state of ANS
+	jxlPrefixMaxBits = 15         // maximum length of a prefix code
+	jxlMaxClusters   = 256        // maximum number of distributions
+	jxlLZ77Window    = 1 << 20    // size of the LZ77 window
+	jxlLZ77WindowMsk = jxlLZ77Window - 1
+)
+
+// set an error of malformed data, keeping the first one
+func (r *jxlBitReader) fail(format string, a ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, a...)
+	}
+}
+
+// ceil(log2(x)) of a positive x
+func jxlCeilLog2(x uint32) int {
+	return bits.Len32(x - 1)
+}
+
+// a configuration of hybrid integers; a token is split into its high bits and raw low bits
+type jxlUintConfig struct {
+	splitExponent, msbInToken, lsbInToken uint32
+}
+
+// read a HybridUintConfig
+func (r *jxlBitReader) uintConfig(logAlphaSize int) (c jxlUintConfig) {
+	c.splitExponent = uint32(r.u(jxlCeilLog2(uint32(logAlphaSize) + 1)))
+	if c.splitExponent != uint32(logAlphaSize) {
+		c.msbInToken = uint32(r.u(jxlCeilLog2(c.splitExponent + 1)))
+		if c.msbInToken > c.splitExponent {
+			r.fail("invalid JPEG XL hybrid integer configuration")
+			return
+		}
+		c.lsbInToken = uint32(r.u(jxlCeilLog2(c.splitExponent - c.msbInToken + 1)))
+	}
+	if c.msbInToken+c.lsbInToken > c.splitExponent {
+		r.fail("invalid JPEG XL hybrid integer configuration")
+	}
+	return
+}
+
+// read the raw bits of a hybrid integer of a token
+func (r *jxlBitReader) hybridUint(c jxlUintConfig, token uint32) uint32 {
+	split := uint32(1) << c.splitExponent
+	if token < split {
+		return token
+	}
+	n := c.splitExponent - (c.msbInToken + c.lsbInToken) + (token-split)>>(c.msbInToken+c.lsbInToken)
+	if n > 32 {
+		r.fail("invalid JPEG XL hybrid integer")
+		return 0
+	}
+	low := token & (1<<c.lsbInToken - 1)
+	token >>= c.lsbInToken
+	high := uint64(token&(1<<c.msbInToken-1) | 1<<c.msbInToken)
+	return uint32(((high<<n | r.u(int(n))) << c.lsbInToken) | uint64(low))
+}
+
+// read a number of 0 to 255
+func (r *jxlBitReader) varLenUint8() int {
+	if !r.bool() {
+		return 0
+	}
+	n := int(r.u(3))
+	if n == 0 {
+		return 1
+	}
+	return int(r.u(n)) + 1<<n
+}
+
+// read a number of 0 to 65535
+func (r *jxlBitReader) varLenUint16() int {
+	if !r.bool() {
+		return 0
+	}
+	n := int(r.u(4))
+	if n == 0 {
+		return 1
+	}
+	return int(r.u(n)) + 1<<n
+}
+
+// a canonical prefix code
+type jxlPrefixCode struct {
+	counts  [jxlPrefixMaxBits + 1]int // number of codes of each length
+	symbols []uint32                  // symbols in the order of codes
+	single  int                       // the symbol of a code without bits, or -1
+}
+
+// build a prefix code from the code lengths of symbols.
+// A code of a single symbol has no bits.
+func newJXLPrefixCode(lengths []uint8) *jxlPrefixCode {
+	c := &jxlPrefixCode{single: -1}
+	for l := 1; l <= jxlPrefixMaxBits; l++ {
+		for s, sl := range lengths {
+			if int(sl) == l {
+				c.counts[l]++
+				c.symbols = append(c.symbols, uint32(s))
+			}
+		}
+	}
+	if len(c.symbols) == 1 {
+		c.single = int(c.symbols[0])
+	}
+	return c
+}
+
+// read a symbol; the first bit read is the most significant bit of the code
+func (c *jxlPrefixCode) decode(r *jxlBitReader) uint32 {
+	if c.single >= 0 {
+		return uint32(c.single)
+	}
+	code, first, index := 0, 0, 0
+	for l := 1; l <= jxlPrefixMaxBits; l++ {
+		code |= int(r.u(1))
+		count := c.counts[l]
+		if code-first < count {
+			return c.symbols[index+code-first]
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	r.fail("invalid JPEG XL prefix code")
+	return 0
+}
+
+// order of the code length code lengths
+var jxlCodeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// read a code length code length by its static prefix code
+func (r *jxlBitReader) codeLengthCodeLength() uint8 {
+	switch r.u(2) {
+	case 0:
+		return 0
+	case 1:
+		return 4
+	case 2:
+		return 3
+	}
+	if r.u(1) == 0 {
+		return 2
+	}
+	if r.u(1) == 0 {
+		return 1
+	}
+	return 5
+}
+
+// read a simple prefix code of 1 to 4 symbols
+func (r *jxlBitReader) simplePrefixCode(alphabetSize int) *jxlPrefixCode {
+	maxBits := bits.Len(uint(alphabetSize - 1))
+	n := int(r.u(2)) + 1
+	symbols := make([]int, n)
+	for i := range symbols {
+		symbols[i] = int(r.u(maxBits))
+		if symbols[i] >= alphabetSize {
+			r.fail("invalid JPEG XL prefix code symbol")
+			return nil
+		}
+		for j := 0; j < i; j++ {
+			if symbols[j] == symbols[i] {
+				r.fail("duplicate JPEG XL prefix code symbol")
+				return nil
+			}
+		}
+	}
+	var codeLengths []uint8
+	switch n {
+	case 1:
+		codeLengths = []uint8{0}
+	case 2:
+		codeLengths = []uint8{1, 1}
+	case 3:
+		codeLengths = []uint8{1, 2, 2}
+	case 4:
+		if r.bool() { // tree-select
+			codeLengths = []uint8{1, 2, 3, 3}
+		} else {
+			codeLengths = []uint8{2, 2, 2, 2}
+		}
+	}
+	if n == 1 {
+		return &jxlPrefixCode{single: symbols[0]}
+	}
+	lengths := make([]uint8, alphabetSize)
+	for i, s := range symbols {
+		lengths[s] = codeLengths[i]
+	}
+	return newJXLPrefixCode(lengths)
+}
+
+// read a prefix code of an alphabet, in the format of Brotli
+func (r *jxlBitReader) prefixCode(alphabetSize int) *jxlPrefixCode {
+	if alphabetSize == 1 {
+		return &jxlPrefixCode{single: 0}
+	}
+	skip := int(r.u(2))
+	if skip == 1 {
+		return r.simplePrefixCode(alphabetSize)
+	}
+
+	// the code of code lengths
+	var clcl [18]uint8
+	space, numCodes := 32, 0
+	for i := skip; i < len(clcl) && space > 0; i++ {
+		v := r.codeLengthCodeLength()
+		clcl[jxlCodeLengthOrder[i]] = v
+		if v != 0 {
+			space -= 32 >> v
+			numCodes++
+		}
+	}
+	if numCodes != 1 && space != 0 {
+		r.fail("invalid JPEG XL code length code")
+		return nil
+	}
+	clc := newJXLPrefixCode(clcl[:])
+
+	// code lengths; 16 repeats the previous non-zero length and 17 repeats zeros
+	lengths := make([]uint8, alphabetSize)
+	prevLen, repeatLen := uint8(8), uint8(0)
+	repeat, symbol := 0, 0
+	space = 1 << 15
+	for symbol < alphabetSize && space > 0 && r.err == nil {
+		l := uint8(clc.decode(r))
+		if l < 16 {
+			repeat = 0
+			lengths[symbol] = l
+			symbol++
+			if l != 0 {
+				prevLen = l
+				space -= 1 << 15 >> l
+			}
+			continue
+		}
+		extraBits, newLen := 3, uint8(0)
+		if l == 16 {
+			extraBits, newLen = 2, prevLen
+		}
+		if repeatLen != newLen {
+			repeat, repeatLen = 0, newLen
+		}
+		oldRepeat := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extraBits
+		}
+		repeat += int(r.u(extraBits)) + 3
+		delta := repeat - oldRepeat
+		if symbol+delta > alphabetSize {
+			r.fail("invalid JPEG XL code lengths")
+			return nil
+		}
+		for i := 0; i < delta; i++ {
+			lengths[symbol+i] = repeatLen
+		}
+		symbol += delta
+		if repeatLen != 0 {
+			space -= delta << (15 - repeatLen)
+		}
+	}
+	if space != 0 {
+		r.fail("invalid JPEG XL code lengths")
+		return nil
+	}
+	return newJXLPrefixCode(lengths)
+}
+
+// read a log2 count of an ANS distribution by its static prefix code
+func (r *jxlBitReader) ansLogCount() int {
+	switch r.u(3) {
+	case 0:
+		return 10
+	case 2:
+		return 7
+	case 3:
+		if r.u(1) == 0 {
+			return 3
+		}
+		return 1
+	case 4:
+		return 6
+	case 5:
+		return 8
+	case 6:
+		return 9
+	case 7:
+		if r.u(1) == 0 {
+			return 5
+		}
+		return 2
+	}
+	// 1
+	if r.u(1) == 1 {
+		return 4
+	}
+	if r.u(1) == 1 {
+		return 0
+	}
+	if r.u(1) == 1 {
+		return 11
+	}
+	return 12 + int(r.u(1))
+}
+
+// read an ANS distribution; the counts sum to jxlANSTabSize
+func (r *jxlBitReader) ansDistribution() (counts []int) {
+	if r.bool() { // simple distribution of 1 or 2 symbols
+		n := int(r.u(1)) + 1
+		var symbols [2]int
+		maxSymbol := 0
+		for i := 0; i < n; i++ {
+			symbols[i] = r.varLenUint8()
+			if symbols[i] > maxSymbol {
+				maxSymbol = symbols[i]
+			}
+		}
+		counts = make([]int, maxSymbol+1)
+		if n == 1 {
+			counts[symbols[0]] = jxlANSTabSize
+			return
+		}
+		if symbols[0] == symbols[1] {
+			r.fail("invalid JPEG XL distribution")
+			return nil
+		}
+		counts[symbols[0]] = int(r.u(jxlANSLogTabSize))
+		counts[symbols[1]] = jxlANSTabSize - counts[symbols[0]]
+		return
+	}
+	if r.bool() { // flat distribution
+		n := r.varLenUint8() + 1
+		counts = make([]int, n)
+		for i := range counts {
+			counts[i] = jxlANSTabSize / n
+		}
+		for i := 0; i < jxlANSTabSize%n; i++ {
+			counts[i]++
+		}
+		return
+	}
+
+	// precision of the counts
+	log := 0
+	for ; log < bits.Len(jxlANSLogTabSize+1)-1; log++ {
+		if !r.bool() {
+			break
+		}
+	}
+	shift := int(r.u(log)|1<<log) - 1
+	if shift > jxlANSLogTabSize+1 {
+		r.fail("invalid JPEG XL distribution")
+		return nil
+	}
+
+	// log2 counts; 13 is a run of the previous count
+	length := r.varLenUint8() + 3
+	counts = make([]int, length)
+	logCounts := make([]int, length)
+	same := make([]int, length)
+	omitLog, omitPos := -1, -1
+	for i := 0; i < length && r.err == nil; i++ {
+		logCounts[i] = r.ansLogCount()
+		if logCounts[i] == jxlANSLogTabSize+1 {
+			rle := r.varLenUint8()
+			same[i] = rle + 5
+			i += rle + 3
+			continue
+		}
+		if logCounts[i] > omitLog {
+			omitLog, omitPos = logCounts[i], i
+		}
+	}
+	if r.err != nil {
+		return nil
+	}
+	if omitPos < 0 || omitPos+1 < length && logCounts[omitPos+1] == jxlANSLogTabSize+1 {
+		r.fail("invalid JPEG XL distribution")
+		return nil
+	}
+
+	// the count of omitPos is the rest of the total
+	total, prev, numSame := 0, 0, 0
+	for i := 0; i < length; i++ {
+		if same[i] != 0 {
+			numSame = same[i] - 1
+			if i > 0 {
+				prev = counts[i-1]
+			} else {
+				prev = 0
+			}
+		}
+		if numSame > 0 {
+			counts[i] = prev
+			numSame--
+		} else {
+			code := logCounts[i]
+			switch {
+			case i == omitPos || code == 0:
+				continue
+			case code == 1:
+				counts[i] = 1
+			default:
+				// the number of raw bits of the count
+				n := shift - (jxlANSLogTabSize-(code-1))>>1
+				if code-1 < n {
+					n = code - 1
+				}
+				if n < 0 {
+					n = 0
+				}
+				counts[i] = 1<<(code-1) + int(r.u(n))<<(code-1-n)
+			}
+		}
+		total += counts[i]
+	}
+	counts[omitPos] = jxlANSTabSize - total
+	if counts[omitPos] <= 0 {
+		r.fail("invalid JPEG XL distribution")
+		return nil
+	}
+	return
+}
+
+// an entry of an alias table of an ANS distribution
+type jxlAliasEntry struct {
+	cutoff     int // positions below the cutoff are the symbol of the entry
+	rightValue int // the symbol of the other positions
+	offset1    int // offset of the other positions in the frequency range of rightValue
+	freq0      int // frequency of the symbol of the entry
+	freq1      int // frequency of rightValue
+}
+
+// build the alias table of a distribution
+func newJXLAliasTable(counts []int, logAlphaSize int) []jxlAliasEntry {
+	for len(counts) > 0 && counts[len(counts)-1] == 0 {
+		counts = counts[:len(counts)-1]
+	}
+	if len(counts) == 0 {
+		counts = []int{jxlANSTabSize}
+	}
+	tableSize := 1 << logAlphaSize
+	entrySize := jxlANSTabSize >> logAlphaSize
+	table := make([]jxlAliasEntry, tableSize)
+
+	// a single symbol keeps the state unchanged
+	for s, c := range counts {
+		if c == jxlANSTabSize {
+			for i := range table {
+				table[i] = jxlAliasEntry{cutoff: 0, rightValue: s, offset1: entrySize * i, freq0: 0, freq1: jxlANSTabSize}
+			}
+			return table
+		}
+	}
+
+	var underfull, overfull []int
+	cutoffs := make([]int, tableSize)
+	for i := range cutoffs {
+		if i < len(counts) {
+			cutoffs[i] = counts[i]
+		}
+		switch {
+		case cutoffs[i] > entrySize:
+			overfull = append(overfull, i)
+		case cutoffs[i] < entrySize:
+			underfull = append(underfull, i)
+		}
+	}
+	for len(overfull) > 0 {
+		o := overfull[len(overfull)-1]
+		overfull = overfull[:len(overfull)-1]
+		u := underfull[len(underfull)-1]
+		underfull = underfull[:len(underfull)-1]
+		cutoffs[o] -= entrySize - cutoffs[u]
+		table[u].rightValue = o
+		table[u].offset1 = cutoffs[o]
+		switch {
+		case cutoffs[o] < entrySize:
+			underfull = append(underfull, o)
+		case cutoffs[o] > entrySize:
+			overfull = append(overfull, o)
+		}
+	}
+	count := func(s int) int {
+		if s < len(counts) {
+			return counts[s]
+		}
+		return 0
+	}
+	for i := range table {
+		if cutoffs[i] == entrySize {
+			table[i].rightValue = i
+			table[i].offset1 = 0
+			table[i].cutoff = 0
+		} else {
+			table[i].offset1 -= cutoffs[i]
+			table[i].cutoff = cutoffs[i]
+		}
+		table[i].freq0 = count(i)
+		table[i].freq1 = count(table[i].rightValue)
+	}
+	return table
+}
+
+// LZ77 parameters of an entropy code
+type jxlLZ77 struct {
+	enabled    bool
+	minSymbol  uint32 // tokens from minSymbol are copy lengths
+	minLength  uint32
+	lengthConf jxlUintConfig
+}
+
+// a set of entropy codes with a context map
+type jxlEntropyCode struct {
+	lz77         jxlLZ77
+	contextMap   []int // distribution of each context; the last one is of LZ77 distances if enabled
+	usePrefix    bool
+	logAlphaSize int
+	configs      []jxlUintConfig
+	prefixCodes  []*jxlPrefixCode
+	aliasTables  [][]jxlAliasEntry
+}
+
+// read a context map of n contexts, returning the number of distributions
+func (r *jxlBitReader) contextMap(n int) (contextMap []int, numClusters int) {
+	contextMap = make([]int, n)
+	if r.bool() { // simple
+		bitsPerEntry := int(r.u(2))
+		for i := range contextMap {
+			contextMap[i] = int(r.u(bitsPerEntry))
+		}
+	} else {
+		useMTF := r.bool()
+		code := r.entropyCode(1, n <= 2)
+		if r.err != nil {
+			return nil, 0
+		}
+		sr := newJXLSymbolReader(code, r)
+		for i := range contextMap {
+			contextMap[i] = int(sr.readUint(r, 0))
+			if contextMap[i] >= jxlMaxClusters {
+				r.fail("invalid JPEG XL context map")
+				return nil, 0
+			}
+		}
+		if !sr.finalStateOK() {
+			r.fail("invalid JPEG XL context map")
+			return nil, 0
+		}
+		if useMTF {
+			var mtf [256]int
+			for i := range mtf {
+				mtf[i] = i
+			}
+			for i, index := range contextMap {
+				v := mtf[index]
+				contextMap[i] = v
+				copy(mtf[1:index+1], mtf[:index])
+				mtf[0] = v
+			}
+		}
+	}
+
+	// distributions must be numbered without holes
+	used := make(map[int]bool)
+	for _, c := range contextMap {
+		used[c] = true
+		if c+1 > numClusters {
+			numClusters = c + 1
+		}
+	}
+	if len(used) != numClusters {
+		r.fail("incomplete JPEG XL context map")
+		return nil, 0
+	}
+	return
+}
+
+// read the entropy codes of n contexts
+func (r *jxlBitReader) entropyCode(n int, disallowLZ77 bool) *jxlEntropyCode {
+	code := &jxlEntropyCode{}
+	if code.lz77.enabled = r.bool(); code.lz77.enabled {
+		if disallowLZ77 {
+			r.fail("invalid JPEG XL LZ77 usage")
+			return nil
+		}
+		code.lz77.minSymbol = r.u32(jxlDist{224, 0}, jxlDist{512, 0}, jxlDist{4096, 0}, jxlDist{8, 15})
+		code.lz77.minLength = r.u32(jxlDist{3, 0}, jxlDist{4, 0}, jxlDist{5, 2}, jxlDist{9, 8})
+		code.lz77.lengthConf = r.uintConfig(8)
+		n++
+	}
+	numClusters := 1
+	if n > 1 {
+		code.contextMap, numClusters = r.contextMap(n)
+	} else {
+		code.contextMap = []int{0}
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	code.usePrefix = r.bool()
+	if code.usePrefix {
+		code.logAlphaSize = jxlPrefixMaxBits
+	} else {
+		code.logAlphaSize = int(r.u(2)) + 5
+	}
+	code.configs = make([]jxlUintConfig, numClusters)
+	for i := range code.configs {
+		code.configs[i] = r.uintConfig(code.logAlphaSize)
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	if code.usePrefix {
+		sizes := make([]int, numClusters)
+		for i := range sizes {
+			sizes[i] = r.varLenUint16() + 1
+			if sizes[i] > 1<<code.logAlphaSize {
+				r.fail("JPEG XL alphabet too large")
+				return nil
+			}
+		}
+		code.prefixCodes = make([]*jxlPrefixCode, numClusters)
+		for i, size := range sizes {
+			code.prefixCodes[i] = r.prefixCode(size)
+			if r.err != nil {
+				return nil
+			}
+		}
+		return code
+	}
+	code.aliasTables = make([][]jxlAliasEntry, numClusters)
+	for i := range code.aliasTables {
+		counts := r.ansDistribution()
+		if r.err != nil {
+			return nil
+		}
+		for len(counts) > 0 && counts[len(counts)-1] == 0 {
+			counts = counts[:len(counts)-1]
+		}
+		if len(counts) > 1<<code.logAlphaSize {
+			r.fail("JPEG XL alphabet too large")
+			return nil
+		}
+		code.aliasTables[i] = newJXLAliasTable(counts, code.logAlphaSize)
+	}
+	return code
+}
+
+// a reader of symbols of an entropy code
+type jxlSymbolReader struct {
+	code  *jxlEntropyCode
+	state uint32 // ANS state
+
+	// LZ77
+	window                         []uint32
+	numDecoded, copyPos, numToCopy uint32
+}
+
+// start reading symbols; ANS codes begin with the state
+func newJXLSymbolReader(code *jxlEntropyCode, r *jxlBitReader) *jxlSymbolReader {
+	sr := &jxlSymbolReader{code: code, state: jxlANSSignature}
+	if !code.usePrefix {
+		sr.state = uint32(r.u(32))
+	}
+	if code.lz77.enabled {
+		sr.window = make([]uint32, jxlLZ77Window)
+	}
+	return sr
+}
+
+// read a token of a distribution
+func (sr *jxlSymbolReader) readToken(r *jxlBitReader, cluster int) uint32 {
+	if sr.code.usePrefix {
+		return sr.code.prefixCodes[cluster].decode(r)
+	}
+	logEntrySize := uint32(jxlANSLogTabSize - sr.code.logAlphaSize)
+	res := sr.state & (jxlANSTabSize - 1)
+	e := &sr.code.aliasTables[cluster][res>>logEntrySize]
+	pos := int(res & (1<<logEntrySize - 1))
+	symbol, offset, freq := int(res>>logEntrySize), pos, e.freq0
+	if pos >= e.cutoff {
+		symbol, offset, freq = e.rightValue, e.offset1+pos, e.freq1
+	}
+	sr.state = uint32(freq)*(sr.state>>jxlANSLogTabSize) + uint32(offset)
+	if sr.state < 1<<16 {
+		sr.state = sr.state<<16 | uint32(r.u(16))
+	}
+	return uint32(symbol)
+}
+
+// read an integer of a context
+func (sr *jxlSymbolReader) readUint(r *jxlBitReader, ctx int) uint32 {
+	if sr.numToCopy > 0 {
+		return sr.copy()
+	}
+	cluster := sr.code.contextMap[ctx]
+	token := sr.readToken(r, cluster)
+	if sr.window == nil || token < sr.code.lz77.minSymbol {
+		v := r.hybridUint(sr.code.configs[cluster], token)
+		if sr.window != nil {
+			sr.window[sr.numDecoded&jxlLZ77WindowMsk] = v
+			sr.numDecoded++
+		}
+		return v
+	}
+
+	// LZ77 copy of a length and a distance
+	sr.numToCopy = r.hybridUint(sr.code.lz77.lengthConf, token-sr.code.lz77.minSymbol) + sr.code.lz77.minLength
+	distCluster := sr.code.contextMap[len(sr.code.contextMap)-1]
+	distance := r.hybridUint(sr.code.configs[distCluster], sr.readToken(r, distCluster)) + 1
+	if distance > sr.numDecoded {
+		distance = sr.numDecoded
+	}
+	if distance > jxlLZ77Window {
+		distance = jxlLZ77Window
+	}
+	sr.copyPos = sr.numDecoded - distance
+	if distance == 0 {
+		// copies from the start are zeros
+		for i := range sr.window {
+			sr.window[i] = 0
+		}
+	}
+	if sr.numToCopy < sr.code.lz77.minLength {
+		// overflow
+		r.fail("invalid JPEG XL LZ77 length")
+		return 0
+	}
+	return sr.copy()
+}
+
+// copy a value in the LZ77 window
+func (sr *jxlSymbolReader) copy() uint32 {
+	v := sr.window[sr.copyPos&jxlLZ77WindowMsk]
+	sr.copyPos++
+	sr.numToCopy--
+	sr.window[sr.numDecoded&jxlLZ77WindowMsk] = v
+	sr.numDecoded++
+	return v
+}
+
+// report whether an ANS stream ended in the final state
+func (sr *jxlSymbolReader) finalStateOK() bool {
+	return sr.state == jxlANSSignature
+}
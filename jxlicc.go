@@ -0,0 +1,477 @@
+//
+// decode the ICC profile embedded in a JPEG XL codestream
+//   the entropy-coded ICC stream and its predictor of headers, tag tables and tag data
+//
+// JPEG XL spec: ISO/IEC 18181-1
+//
+
+package imageicc
+
+import (
+	"fmt"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+const (
+	jxlICCContexts   = 41      // number of contexts of the ICC stream
+	jxlICCMaxEncSize = 1 << 28 // maximum size of an encoded ICC stream
+	jxlICCHeaderSize = 128
+)
+
+// commands of the main content
+const (
+	jxlICCInsert    = 1  // raw bytes
+	jxlICCShuffle2  = 2  // bytes interleaved by 2
+	jxlICCShuffle4  = 3  // bytes interleaved by 4
+	jxlICCPredict   = 4  // bytes predicted from previous values
+	jxlICCXYZ       = 10 // an XYZType of a single value
+	jxlICCTypeStart = 16 // start of a tag type, followed by 4 zero bytes
+)
+
+// commands of the tag table
+const (
+	jxlICCTagEnd         = 0   // end of the tag table
+	jxlICCTagUnknown     = 1   // signature in the data stream
+	jxlICCTagTRC         = 2   // rTRC, gTRC and bTRC sharing their data
+	jxlICCTagXYZ         = 3   // rXYZ, gXYZ and bXYZ in a row
+	jxlICCTagStringFirst = 4   // signatures of jxlICCTagStrings
+	jxlICCFlagOffset     = 64  // the offset of the tag is stored
+	jxlICCFlagSize       = 128 // the size of the tag is stored
+)
+
+// tag signatures of the tag table commands
+var jxlICCTagStrings = []string{
+	"cprt", "wtpt", "bkpt", "rXYZ", "gXYZ", "bXYZ", "kXYZ", "rTRC", "gTRC", "bTRC", "kTRC",
+	"chad", "desc", "chrm", "dmnd", "dmdd", "lumi",
+}
+
+// tag types of the main content commands
+var jxlICCTypeStrings = []string{"XYZ ", "desc", "text", "mluc", "para", "curv", "sf32", "gbd "}
+
+// kind of the previous byte in the context of the ICC stream
+func jxlICCByteKind1(b byte) int {
+	switch {
+	case 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z':
+		return 0
+	case '0' <= b && b <= '9' || b == '.' || b == ',':
+		return 1
+	case b == 0:
+		return 2
+	case b == 1:
+		return 3
+	case b < 16:
+		return 4
+	case b == 255:
+		return 6
+	case b > 240:
+		return 5
+	}
+	return 7
+}
+
+// kind of the byte before the previous one in the context of the ICC stream
+func jxlICCByteKind2(b byte) int {
+	switch {
+	case 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z':
+		return 0
+	case '0' <= b && b <= '9' || b == '.' || b == ',':
+		return 1
+	case b < 16:
+		return 2
+	case b > 240:
+		return 3
+	}
+	return 4
+}
+
+// context of the i-th byte of the ICC stream
+func jxlICCContext(i int, b1, b2 byte) int {
+	if i <= jxlICCHeaderSize {
+		return 0
+	}
+	return 1 + jxlICCByteKind1(b1) + jxlICCByteKind2(b2)*8
+}
+
+// read the ICC stream following the image header, and restore the profile
+func (r *jxlBitReader) iccStream() (iccProfile []byte, err error) {
+	encSize := r.u64()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if encSize > jxlICCMaxEncSize {
+		return nil, fmt.Errorf("JPEG XL ICC stream too large")
+	}
+	code := r.entropyCode(jxlICCContexts, false)
+	if r.err != nil {
+		return nil, r.err
+	}
+	sr := newJXLSymbolReader(code, r)
+	enc := make([]byte, 0, 1<<12)
+	for i := 0; i < int(encSize) && r.err == nil; i++ {
+		var b1, b2 byte
+		if i > 0 {
+			b1 = enc[i-1]
+		}
+		if i > 1 {
+			b2 = enc[i-2]
+		}
+		enc = append(enc, byte(sr.readUint(r, jxlICCContext(i, b1, b2))))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if !sr.finalStateOK() {
+		return nil, fmt.Errorf("corrupted JPEG XL ICC stream")
+	}
+	return unpredictJXLICC(enc)
+}
+
+// read a varint of the ICC stream
+func jxlICCVarint(b []byte, pos *int) uint64 {
+	var v uint64
+	i := 0
+	for ; *pos+i < len(b) && i < 10; i++ {
+		v |= uint64(b[*pos+i]&127) << (7 * i)
+		if b[*pos+i]&128 == 0 {
+			break
+		}
+	}
+	*pos += i + 1
+	return v
+}
+
+// initial prediction of the ICC header
+func jxlICCHeaderPrediction(size uint64) []byte {
+	h := make([]byte, jxlICCHeaderSize)
+	bst.BigEndian.PutUint32(h, uint32(size))
+	h[8] = 4 // version 4
+	copy(h[12:], ClassDisplay)
+	copy(h[16:], ColorSpaceRGB)
+	copy(h[20:], ColorSpaceXYZ) // PCS
+	copy(h[36:], "acsp")
+	copy(h[68:], []byte{0, 0, 0xf6, 0xd6, 0, 1, 0, 0, 0, 0, 0xd3, 0x2d}) // D50
+	return h
+}
+
+// update the prediction of the ICC header by the first bytes of the profile
+func jxlICCPredictHeader(icc []byte, h []byte, i int) {
+	switch {
+	case i == 8 && len(icc) >= 8:
+		copy(h[80:84], icc[4:8]) // creator is the CMM
+	case i == 41 && len(icc) >= 41:
+		switch icc[40] {
+		case 'A':
+			copy(h[41:44], "PPL")
+		case 'M':
+			copy(h[41:44], "SFT")
+		}
+	case i == 42 && len(icc) >= 42:
+		switch string(icc[40:42]) {
+		case "SG":
+			copy(h[42:44], "I ")
+		case "SU":
+			copy(h[42:44], "NW")
+		}
+	}
+}
+
+// de-interleave bytes of a width
+func jxlICCShuffle(b []byte, width int) {
+	height := (len(b) + width - 1) / width
+	result := make([]byte, len(b))
+	s, j := 0, 0
+	for i := range result {
+		result[i] = b[j]
+		j += height
+		if j >= len(b) {
+			s++
+			j = s
+		}
+	}
+	copy(b, result)
+}
+
+// predict a value from the previous values p1, p2 and p3 by an order
+func jxlICCPredictValue(p1, p2, p3 uint32, order int) uint32 {
+	switch order {
+	case 0:
+		return p1
+	case 1:
+		return 2*p1 - p2
+	}
+	return 3*p1 - 3*p2 + p3
+}
+
+// predict the i-th byte of numbers of a width from start, by the numbers stride bytes before
+func jxlICCLinearPredict(data []byte, start, i, stride, width, order int) byte {
+	pos := start + i
+	if width == 1 {
+		return byte(jxlICCPredictValue(uint32(data[pos-stride]), uint32(data[pos-2*stride]), uint32(data[pos-3*stride]), order))
+	}
+	p := start + i&^(width-1)
+	value := func(k int) uint32 {
+		if k < 0 || k+width > pos {
+			return 0
+		}
+		var v uint32
+		for _, b := range data[k : k+width] {
+			v = v<<8 | uint32(b)
+		}
+		return v
+	}
+	pred := jxlICCPredictValue(value(p-stride), value(p-2*stride), value(p-3*stride), order)
+	if width == 2 {
+		pred &= 0xffff
+	}
+	shift := (width - 1 - i&(width-1)) * 8
+	return byte(pred >> shift)
+}
+
+// append a big-endian uint32
+func appendUint32BE(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// restore an ICC profile from the decoded ICC stream
+func unpredictJXLICC(enc []byte) (data []byte, err error) {
+	errOutOfBounds := fmt.Errorf("JPEG XL ICC stream out of bounds")
+	size := len(enc)
+	pos := 0
+	if pos >= size {
+		return nil, errOutOfBounds
+	}
+	osize := jxlICCVarint(enc, &pos) // size of the profile
+	if pos >= size {
+		return nil, errOutOfBounds
+	}
+	csize := jxlICCVarint(enc, &pos) // size of the commands
+	if osize > 1<<32-1 || csize > 1<<32-1 {
+		return nil, fmt.Errorf("invalid JPEG XL ICC stream")
+	}
+	if uint64(pos)+csize > uint64(size) {
+		return nil, errOutOfBounds
+	}
+	if osize+65536 < uint64(size) {
+		// the predictor does not shrink profiles this much
+		return nil, fmt.Errorf("invalid JPEG XL ICC stream")
+	}
+	// commands from cpos and data from pos
+	cpos := pos
+	commandsEnd := cpos + int(csize)
+	pos = commandsEnd
+	data = make([]byte, 0, 1<<12)
+	varint := func() (uint64, error) {
+		if cpos >= commandsEnd {
+			return 0, errOutOfBounds
+		}
+		return jxlICCVarint(enc, &cpos), nil
+	}
+
+	// header
+	header := jxlICCHeaderPrediction(osize)
+	for i := 0; i <= jxlICCHeaderSize; i++ {
+		if uint64(len(data)) == osize {
+			if cpos != commandsEnd || pos != size {
+				return nil, fmt.Errorf("JPEG XL ICC stream not fully used")
+			}
+			return data, nil
+		}
+		if i == jxlICCHeaderSize {
+			break
+		}
+		jxlICCPredictHeader(data, header, i)
+		if pos >= size {
+			return nil, errOutOfBounds
+		}
+		data = append(data, enc[pos]+header[i])
+		pos++
+	}
+
+	// tag table
+	numTags, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	if numTags != 0 {
+		numTags--
+		if numTags > 1<<32-1 {
+			return nil, fmt.Errorf("invalid JPEG XL ICC stream")
+		}
+		data = appendUint32BE(data, uint32(numTags))
+		prevStart, prevSize := uint64(jxlICCHeaderSize)+numTags*12, uint64(0)
+		for {
+			if uint64(len(data)) > osize {
+				return nil, fmt.Errorf("invalid JPEG XL ICC stream size")
+			}
+			if cpos > commandsEnd {
+				return nil, errOutOfBounds
+			}
+			if cpos == commandsEnd {
+				break
+			}
+			command := enc[cpos]
+			cpos++
+			tagCode := int(command & 63)
+			var tag string
+			switch {
+			case tagCode == jxlICCTagEnd:
+			case tagCode == jxlICCTagUnknown:
+				if pos+4 > size {
+					return nil, errOutOfBounds
+				}
+				tag = string(enc[pos : pos+4])
+				pos += 4
+			case tagCode == jxlICCTagTRC:
+				tag = "rTRC"
+			case tagCode == jxlICCTagXYZ:
+				tag = "rXYZ"
+			case tagCode-jxlICCTagStringFirst < len(jxlICCTagStrings):
+				tag = jxlICCTagStrings[tagCode-jxlICCTagStringFirst]
+			default:
+				return nil, fmt.Errorf("unknown JPEG XL ICC tag code %d", tagCode)
+			}
+			if tagCode == jxlICCTagEnd {
+				break
+			}
+			data = append(data, tag...)
+
+			tagStart, tagSize := prevStart+prevSize, prevSize
+			switch tag {
+			case "rXYZ", "gXYZ", "bXYZ", "kXYZ", "wtpt", "bkpt", "lumi":
+				tagSize = 20
+			}
+			if command&jxlICCFlagOffset != 0 {
+				if tagStart, err = varint(); err != nil {
+					return nil, err
+				}
+			}
+			if command&jxlICCFlagSize != 0 {
+				if tagSize, err = varint(); err != nil {
+					return nil, err
+				}
+			}
+			if tagStart > 1<<32-1 || tagSize > 1<<32-1 || tagStart+2*tagSize > 1<<32-1 {
+				return nil, fmt.Errorf("invalid JPEG XL ICC tag")
+			}
+			data = appendUint32BE(data, uint32(tagStart))
+			data = appendUint32BE(data, uint32(tagSize))
+			prevStart, prevSize = tagStart, tagSize
+
+			switch tagCode {
+			case jxlICCTagTRC:
+				for _, t := range []string{"gTRC", "bTRC"} {
+					data = append(data, t...)
+					data = appendUint32BE(data, uint32(tagStart))
+					data = appendUint32BE(data, uint32(tagSize))
+				}
+			case jxlICCTagXYZ:
+				for i, t := range []string{"gXYZ", "bXYZ"} {
+					data = append(data, t...)
+					data = appendUint32BE(data, uint32(tagStart+uint64(i+1)*tagSize))
+					data = appendUint32BE(data, uint32(tagSize))
+				}
+			}
+		}
+	}
+
+	// main content
+	for {
+		if uint64(len(data)) > osize {
+			return nil, fmt.Errorf("invalid JPEG XL ICC stream size")
+		}
+		if cpos > commandsEnd {
+			return nil, errOutOfBounds
+		}
+		if cpos == commandsEnd {
+			break
+		}
+		command := enc[cpos]
+		cpos++
+		switch {
+		case command == jxlICCInsert || command == jxlICCShuffle2 || command == jxlICCShuffle4:
+			num, err := varint()
+			if err != nil {
+				return nil, err
+			}
+			if num > uint64(size-pos) {
+				return nil, errOutOfBounds
+			}
+			b := append([]byte(nil), enc[pos:pos+int(num)]...)
+			switch command {
+			case jxlICCShuffle2:
+				jxlICCShuffle(b, 2)
+			case jxlICCShuffle4:
+				jxlICCShuffle(b, 4)
+			}
+			data = append(data, b...)
+			pos += int(num)
+
+		case command == jxlICCPredict:
+			if cpos+2 > commandsEnd {
+				return nil, errOutOfBounds
+			}
+			flags := enc[cpos]
+			cpos++
+			width := int(flags&3) + 1
+			if width == 3 {
+				return nil, fmt.Errorf("invalid JPEG XL ICC prediction width")
+			}
+			order := int(flags&12) >> 2
+			if order == 3 {
+				return nil, fmt.Errorf("invalid JPEG XL ICC prediction order")
+			}
+			stride := uint64(width)
+			if flags&16 != 0 {
+				if stride, err = varint(); err != nil {
+					return nil, err
+				}
+				if stride < uint64(width) {
+					return nil, fmt.Errorf("invalid JPEG XL ICC prediction stride")
+				}
+			}
+			// the values to predict from must be in the profile; stride*4 >= len(data) is invalid
+			if len(data) == 0 || uint64(len(data)-1)>>2 < stride {
+				return nil, fmt.Errorf("invalid JPEG XL ICC prediction stride")
+			}
+			num, err := varint()
+			if err != nil {
+				return nil, err
+			}
+			if num > uint64(size-pos) {
+				return nil, errOutOfBounds
+			}
+			b := append([]byte(nil), enc[pos:pos+int(num)]...)
+			if width > 1 {
+				jxlICCShuffle(b, width)
+			}
+			start := len(data)
+			for i, v := range b {
+				data = append(data, jxlICCLinearPredict(data, start, i, int(stride), width, order)+v)
+			}
+			pos += int(num)
+
+		case command == jxlICCXYZ:
+			data = append(data, "XYZ \x00\x00\x00\x00"...)
+			if pos+12 > size {
+				return nil, errOutOfBounds
+			}
+			data = append(data, enc[pos:pos+12]...)
+			pos += 12
+
+		case command >= jxlICCTypeStart && int(command) < jxlICCTypeStart+len(jxlICCTypeStrings):
+			data = append(data, jxlICCTypeStrings[command-jxlICCTypeStart]...)
+			data = append(data, 0, 0, 0, 0)
+
+		default:
+			return nil, fmt.Errorf("unknown JPEG XL ICC command %d", command)
+		}
+	}
+	if pos != size {
+		return nil, fmt.Errorf("JPEG XL ICC stream not fully used")
+	}
+	if uint64(len(data)) != osize {
+		return nil, fmt.Errorf("invalid JPEG XL ICC stream size")
+	}
+	return data, nil
+}
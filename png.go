@@ -1,5 +1,5 @@
 //
-// read embedded ICC profile from a PNG file
+// read and write embedded ICC profile in a PNG file
 //
 // PNG spec
 // https://www.w3.org/TR/2003/REC-PNG-20031110/
@@ -195,3 +195,162 @@ func LoadICCfromPNGWithName(in io.ReadSeeker) (iccProfile []byte, profileName st
 
 	return iccProfile, iccpChunk.Name, nil
 }
+
+// write a PNG chunk with its CRC32 value
+func writePNGChunk(w io.Writer, chunkType string, data []byte) (err error) {
+	ch := pngChunk{DataLen: len(data), Type: chunkType}
+	_, err = bst.Write(w, bst.BigEndian, &ch)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return
+	}
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	_, err = bst.Write(w, bst.BigEndian, crc.Sum32())
+	return
+}
+
+// copy a chunk in the source PNG as is, validating its CRC
+func copyPNGChunk(w io.Writer, in io.ReadSeeker, ch pngChunk) (err error) {
+	_, err = in.Seek(ch.DataOffset-8, io.SeekStart)
+	if err != nil {
+		return
+	}
+	r := newCrcReader(in)
+	_, err = io.CopyN(w, in, 8) // chunk header
+	if err != nil {
+		return
+	}
+	r.ResetCRC([]byte(ch.Type))
+	_, err = io.CopyN(w, r, int64(ch.DataLen))
+	if err != nil {
+		return
+	}
+	var chunkCRC32 uint32
+	_, err = bst.Read(in, bst.BigEndian, &chunkCRC32)
+	if err != nil {
+		return
+	}
+	if chunkCRC32 != r.Crc.Sum32() {
+		err = fmt.Errorf("chunk %s has invalid CRC", ch.Type)
+		return
+	}
+	_, err = bst.Write(w, bst.BigEndian, chunkCRC32)
+	return
+}
+
+// build the data of an iCCP chunk
+func makeICCPChunkData(iccProfile []byte, profileName string) (data []byte, err error) {
+	if profileName == "" {
+		profileName = "ICC Profile"
+	}
+	if len(profileName) > 79 {
+		err = fmt.Errorf("profile name too long")
+		return
+	}
+	err = checkPNGKeyword(profileName)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(profileName)
+	buf.WriteByte(0) // null separator
+	buf.WriteByte(0) // compression method: zlib deflate
+	zw := zlib.NewWriter(&buf)
+	_, err = zw.Write(iccProfile)
+	if err != nil {
+		return
+	}
+	err = zw.Close()
+	if err != nil {
+		return
+	}
+	return buf.Bytes(), nil
+}
+
+// check that a PNG keyword contains only printable Latin-1 characters (32-126, 161-255),
+// and has no leading, trailing or consecutive spaces
+func checkPNGKeyword(keyword string) error {
+	for i := 0; i < len(keyword); i++ {
+		c := keyword[i]
+		if c < 32 || (c > 126 && c < 161) {
+			return fmt.Errorf("invalid character 0x%02x in profile name", c)
+		}
+		if c == ' ' && (i == 0 || i == len(keyword)-1 || keyword[i-1] == ' ') {
+			return fmt.Errorf("profile name has leading, trailing or consecutive spaces")
+		}
+	}
+	return nil
+}
+
+// copy a PNG stream, removing existing iCCP chunks
+// and inserting a new iCCP chunk if iccProfile is not nil.
+// sRGB chunks are also removed when a new profile is inserted.
+func rewritePNGICC(out io.Writer, in io.ReadSeeker, iccProfile []byte, profileName string) (err error) {
+	var iccpData []byte
+	if iccProfile != nil {
+		iccpData, err = makeICCPChunkData(iccProfile, profileName)
+		if err != nil {
+			return
+		}
+	}
+
+	img, err := parsePNG(in)
+	if err != nil {
+		return
+	}
+	if len(img.Chunk) == 0 || img.Chunk[0].Type != "IHDR" {
+		err = fmt.Errorf("IHDR chunk not found")
+		return
+	}
+
+	_, err = out.Write(pngHeader)
+	if err != nil {
+		return
+	}
+	for _, ch := range img.Chunk {
+		switch ch.Type {
+		case "iCCP":
+			continue
+		case "sRGB": // iCCP and sRGB chunks are mutually exclusive
+			if iccProfile != nil {
+				continue
+			}
+		case "PLTE", "IDAT", "IEND":
+			// iCCP must precede PLTE and IDAT
+			if iccpData != nil {
+				err = writePNGChunk(out, "iCCP", iccpData)
+				if err != nil {
+					return
+				}
+				iccpData = nil
+			}
+		}
+		err = copyPNGChunk(out, in, ch)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Copy a PNG file from in to out, embedding an ICC profile.
+// profileName is stored in the iCCP chunk along with the profile; "ICC Profile" is used if empty.
+// It must be 1-79 printable Latin-1 bytes without leading, trailing or consecutive spaces.
+// Any iCCP or sRGB chunk already in the file is removed.
+func EmbedICCtoPNG(out io.Writer, in io.ReadSeeker, iccProfile []byte, profileName string) (err error) {
+	if len(iccProfile) == 0 {
+		err = fmt.Errorf("empty icc profile")
+		return
+	}
+	return rewritePNGICC(out, in, iccProfile, profileName)
+}
+
+// Copy a PNG file from in to out, removing the embedded ICC profile.
+func StripICCfromPNG(out io.Writer, in io.ReadSeeker) (err error) {
+	return rewritePNGICC(out, in, nil, "")
+}
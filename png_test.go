@@ -0,0 +1,116 @@
+package imageicc
+
+import (
+	"bytes"
+	imgpng "image/png"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestEmbedICCtoPNG(t *testing.T) {
+	var src bytes.Buffer
+	err := imgpng.Encode(&src, testImage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	icc := testProfile(3000, 1)
+	var dst bytes.Buffer
+	err = EmbedICCtoPNG(&dst, bytes.NewReader(src.Bytes()), icc, "Test Profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, name, err := LoadICCfromPNGWithName(bytes.NewReader(dst.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc) || name != "Test Profile" {
+		t.Fatalf("embedded profile mismatch")
+	}
+
+	// replace the profile
+	icc2 := testProfile(500, 2)
+	var dst2 bytes.Buffer
+	err = EmbedICCtoPNG(&dst2, bytes.NewReader(dst.Bytes()), icc2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := parsePNG(bytes.NewReader(dst2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.ChunkByType["iCCP"]) != 1 {
+		t.Fatalf("iCCP chunk count mismatch")
+	}
+	loaded, name, err = LoadICCfromPNGWithName(bytes.NewReader(dst2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, icc2) || name != "ICC Profile" {
+		t.Fatalf("replaced profile mismatch")
+	}
+
+	// pixel data must be intact
+	_, err = imgpng.Decode(bytes.NewReader(dst2.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid profile names
+	for _, name := range []string{" Profile", "Profile ", "ICC  Profile", "ICC\x00Profile", "ICC\tProfile", "Profil\x85", "Profil\x7f"} {
+		err = EmbedICCtoPNG(io.Discard, bytes.NewReader(src.Bytes()), icc, name)
+		if err == nil {
+			t.Errorf("profile name %q accepted", name)
+		}
+	}
+	err = EmbedICCtoPNG(io.Discard, bytes.NewReader(src.Bytes()), icc, "Profil\xe9 1")
+	if err != nil {
+		t.Errorf("Latin-1 profile name rejected: %v", err)
+	}
+}
+
+func TestLibpngSample(t *testing.T) {
+	// written by libpng's png_set_iCCP
+	src := testSample(t, "libpng-display-p3.png")
+	want := testSample(t, "display-p3-v2.icc")
+	loaded, name, err := LoadICCfromPNGWithName(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, want) || name != "Display P3" {
+		t.Fatalf("profile %q of %d bytes differs from the source profile", name, len(loaded))
+	}
+	img, err := imgpng.Decode(bytes.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replace and strip; the image must stay the same
+	icc := testSample(t, "large-v2.icc")
+	var dst bytes.Buffer
+	err = EmbedICCtoPNG(&dst, bytes.NewReader(src), icc, "Large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, name, err = LoadICCfromPNGWithName(bytes.NewReader(dst.Bytes())); err != nil || !bytes.Equal(loaded, icc) || name != "Large" {
+		t.Fatalf("replaced profile mismatch: %v", err)
+	}
+	var stripped bytes.Buffer
+	err = StripICCfromPNG(&stripped, bytes.NewReader(dst.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadICCfromPNG(bytes.NewReader(stripped.Bytes())); err != nil || loaded != nil {
+		t.Fatalf("profile not removed: %v", err)
+	}
+	for _, b := range [][]byte{dst.Bytes(), stripped.Bytes()} {
+		got, err := imgpng.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, img) {
+			t.Errorf("image changed")
+		}
+	}
+}
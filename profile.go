@@ -0,0 +1,492 @@
+//
+// parse and write the header and the tag table of an ICC profile
+//
+// ICC profile spec
+// https://www.color.org/specification/ICC.1-2022-05.pdf
+//
+
+package imageicc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"math"
+	"time"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// size of the ICC profile header
+const profileHeaderSize = 128
+
+// the magic number 'acsp' at offset 36 of the header
+const profileMagic = "acsp"
+
+// profile/device class signatures
+const (
+	ClassInput      = "scnr" // input device profile
+	ClassDisplay    = "mntr" // display device profile
+	ClassOutput     = "prtr" // output device profile
+	ClassLink       = "link" // DeviceLink profile
+	ClassColorSpace = "spac" // ColorSpace profile
+	ClassAbstract   = "abst" // abstract profile
+	ClassNamedColor = "nmcl" // NamedColor profile
+)
+
+// color space signatures
+const (
+	ColorSpaceXYZ  = "XYZ "
+	ColorSpaceLab  = "Lab "
+	ColorSpaceLuv  = "Luv "
+	ColorSpaceYCbr = "YCbr"
+	ColorSpaceYxy  = "Yxy "
+	ColorSpaceRGB  = "RGB "
+	ColorSpaceGray = "GRAY"
+	ColorSpaceHSV  = "HSV "
+	ColorSpaceHLS  = "HLS "
+	ColorSpaceCMYK = "CMYK"
+	ColorSpaceCMY  = "CMY "
+)
+
+// number of channels of color spaces
+var colorSpaceChannels = map[string]int{
+	ColorSpaceXYZ: 3, ColorSpaceLab: 3, ColorSpaceLuv: 3, ColorSpaceYCbr: 3, ColorSpaceYxy: 3,
+	ColorSpaceRGB: 3, ColorSpaceGray: 1, ColorSpaceHSV: 3, ColorSpaceHLS: 3,
+	ColorSpaceCMYK: 4, ColorSpaceCMY: 3,
+	"2CLR": 2, "3CLR": 3, "4CLR": 4, "5CLR": 5, "6CLR": 6, "7CLR": 7, "8CLR": 8,
+	"9CLR": 9, "ACLR": 10, "BCLR": 11, "CCLR": 12, "DCLR": 13, "ECLR": 14, "FCLR": 15,
+}
+
+// Number of channels of a color space signature. 0 is returned for unknown color spaces.
+func ColorSpaceChannels(colorSpace string) int {
+	return colorSpaceChannels[colorSpace]
+}
+
+// RenderingIntent is an ICC rendering intent.
+type RenderingIntent int
+
+const (
+	IntentPerceptual           RenderingIntent = 0
+	IntentRelativeColorimetric RenderingIntent = 1
+	IntentSaturation           RenderingIntent = 2
+	IntentAbsoluteColorimetric RenderingIntent = 3
+)
+
+func (ri RenderingIntent) String() string {
+	switch ri {
+	case IntentPerceptual:
+		return "perceptual"
+	case IntentRelativeColorimetric:
+		return "relative colorimetric"
+	case IntentSaturation:
+		return "saturation"
+	case IntentAbsoluteColorimetric:
+		return "absolute colorimetric"
+	}
+	return fmt.Sprintf("RenderingIntent(%d)", int(ri))
+}
+
+// profile flags
+const (
+	FlagEmbedded    = 1 << 0 // the profile is embedded in a file
+	FlagIndependent = 1 << 1 // the profile cannot be used independently of the embedded color data
+)
+
+// XYZ is a CIE XYZ color value.
+type XYZ struct {
+	X, Y, Z float64
+}
+
+// ProfileVersion is the version of the ICC spec a profile conforms to.
+type ProfileVersion struct {
+	Major, Minor, Bugfix int
+}
+
+func (v ProfileVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Bugfix)
+}
+
+// ProfileHeader is the decoded 128-byte header of an ICC profile.
+// Signatures are 4-char strings, padded with spaces or zero bytes.
+type ProfileHeader struct {
+	Size            int             // profile size in bytes
+	PreferredCMM    string          // preferred CMM type signature
+	Version         ProfileVersion  // profile version
+	DeviceClass     string          // profile/device class, one of Class*
+	ColorSpace      string          // data color space, one of ColorSpace*
+	PCS             string          // profile connection space, ColorSpaceXYZ or ColorSpaceLab
+	Created         time.Time       // date and time the profile was created, in UTC
+	Platform        string          // primary platform signature
+	Flags           uint32          // profile flags
+	Manufacturer    string          // device manufacturer signature
+	Model           string          // device model signature
+	Attributes      uint64          // device attributes
+	RenderingIntent RenderingIntent // default rendering intent
+	Illuminant      XYZ             // PCS illuminant, usually D50
+	Creator         string          // profile creator signature
+	ProfileID       [16]byte        // MD5 profile ID; all zero if not computed
+}
+
+// the header in binary layout
+type rawProfileHeader struct {
+	Size            int    `binary:"uint32"`
+	PreferredCMM    string `binary:"[4]byte"`
+	VersionMajor    int    `binary:"uint8"`
+	VersionMinor    int    `binary:"uint8"` // minor and bugfix in BCD nibbles
+	_               int    `binary:"pad(2)"`
+	DeviceClass     string `binary:"[4]byte"`
+	ColorSpace      string `binary:"[4]byte"`
+	PCS             string `binary:"[4]byte"`
+	Date            [6]int `binary:"[6]uint16"` // year, month, day, hour, minute, second
+	Magic           string `binary:"[4]byte"`
+	Platform        string `binary:"[4]byte"`
+	Flags           uint32
+	Manufacturer    string `binary:"[4]byte"`
+	Model           string `binary:"[4]byte"`
+	Attributes      uint64
+	RenderingIntent int      `binary:"uint32"`
+	Illuminant      [3]int32 // s15Fixed16Number
+	Creator         string   `binary:"[4]byte"`
+	ProfileID       [16]byte
+	_               int `binary:"pad(28)"`
+}
+
+// convert a s15Fixed16Number to float
+func s15f16ToFloat(v int32) float64 {
+	return float64(v) / 65536
+}
+
+// convert a float to a s15Fixed16Number
+func floatToS15f16(v float64) int32 {
+	return int32(math.Round(v * 65536))
+}
+
+// a 4-byte signature field; binary marshalling requires non-empty strings
+func signatureField(s string) string {
+	b := []byte("\x00\x00\x00\x00")
+	copy(b, s)
+	return string(b)
+}
+
+// encode the header of an ICC profile
+func encodeProfileHeader(h *ProfileHeader) (b []byte, err error) {
+	raw := rawProfileHeader{
+		Size:            h.Size,
+		PreferredCMM:    signatureField(h.PreferredCMM),
+		VersionMajor:    h.Version.Major,
+		VersionMinor:    h.Version.Minor<<4 | h.Version.Bugfix&0xf,
+		DeviceClass:     signatureField(h.DeviceClass),
+		ColorSpace:      signatureField(h.ColorSpace),
+		PCS:             signatureField(h.PCS),
+		Magic:           profileMagic,
+		Platform:        signatureField(h.Platform),
+		Flags:           h.Flags,
+		Manufacturer:    signatureField(h.Manufacturer),
+		Model:           signatureField(h.Model),
+		Attributes:      h.Attributes,
+		RenderingIntent: int(h.RenderingIntent),
+		Illuminant: [3]int32{
+			floatToS15f16(h.Illuminant.X),
+			floatToS15f16(h.Illuminant.Y),
+			floatToS15f16(h.Illuminant.Z),
+		},
+		Creator:   signatureField(h.Creator),
+		ProfileID: h.ProfileID,
+	}
+	if !h.Created.IsZero() {
+		t := h.Created.UTC()
+		raw.Date = [6]int{t.Year(), int(t.Month()), t.Day(), t.Hour(), t.Minute(), t.Second()}
+	}
+	return bst.Marshal(&raw, bst.BigEndian)
+}
+
+// Parse the header of an ICC profile.
+func ParseProfileHeader(iccProfile []byte) (header *ProfileHeader, err error) {
+	if len(iccProfile) < profileHeaderSize {
+		err = fmt.Errorf("icc profile too short")
+		return
+	}
+	var raw rawProfileHeader
+	_, err = bst.Unmarshal(iccProfile[:profileHeaderSize], bst.BigEndian, &raw)
+	if err != nil {
+		return
+	}
+	if raw.Magic != profileMagic {
+		err = fmt.Errorf("invalid icc profile signature")
+		return
+	}
+
+	h := &ProfileHeader{
+		Size:         raw.Size,
+		PreferredCMM: raw.PreferredCMM,
+		Version: ProfileVersion{
+			Major:  raw.VersionMajor,
+			Minor:  raw.VersionMinor >> 4,
+			Bugfix: raw.VersionMinor & 0xf,
+		},
+		DeviceClass:     raw.DeviceClass,
+		ColorSpace:      raw.ColorSpace,
+		PCS:             raw.PCS,
+		Platform:        raw.Platform,
+		Flags:           raw.Flags,
+		Manufacturer:    raw.Manufacturer,
+		Model:           raw.Model,
+		Attributes:      raw.Attributes,
+		RenderingIntent: RenderingIntent(raw.RenderingIntent),
+		Illuminant: XYZ{
+			s15f16ToFloat(raw.Illuminant[0]),
+			s15f16ToFloat(raw.Illuminant[1]),
+			s15f16ToFloat(raw.Illuminant[2]),
+		},
+		Creator:   raw.Creator,
+		ProfileID: raw.ProfileID,
+	}
+	d := raw.Date
+	if d[0] != 0 {
+		h.Created = time.Date(d[0], time.Month(d[1]), d[2], d[3], d[4], d[5], 0, time.UTC)
+	}
+	return h, nil
+}
+
+// header fields excluded from the profile ID: flags, rendering intent and profile ID
+var profileIDExcluded = [][2]int{{44, 48}, {64, 68}, {84, 100}}
+
+// compute the MD5 profile ID of a profile, without validation
+func profileID(iccProfile []byte) [16]byte {
+	h := md5.New()
+	last := 0
+	zero := make([]byte, 16)
+	for _, r := range profileIDExcluded {
+		h.Write(iccProfile[last:r[0]])
+		h.Write(zero[:r[1]-r[0]])
+		last = r[1]
+	}
+	h.Write(iccProfile[last:])
+	var id [16]byte
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// Compute the profile ID of an ICC profile, the MD5 digest of the profile
+// with the profile flags, the rendering intent and the profile ID fields of the header set to zero.
+// Bytes beyond the profile size in the header are ignored.
+func ComputeProfileID(iccProfile []byte) (id [16]byte, err error) {
+	if len(iccProfile) < profileHeaderSize {
+		err = fmt.Errorf("icc profile too short")
+		return
+	}
+	size := int(bst.BigEndian.Uint32(iccProfile))
+	if size < profileHeaderSize {
+		err = fmt.Errorf("invalid icc profile size")
+		return
+	}
+	if size > len(iccProfile) {
+		err = fmt.Errorf("icc profile truncated")
+		return
+	}
+	return profileID(iccProfile[:size]), nil
+}
+
+// ProfileIDStatus is the result of a profile ID verification.
+type ProfileIDStatus int
+
+const (
+	ProfileIDMissing  ProfileIDStatus = iota // the profile has no profile ID; usual for v2 profiles
+	ProfileIDValid                           // the stored profile ID matches the profile
+	ProfileIDMismatch                        // the stored profile ID does not match; the profile is modified or corrupted
+)
+
+func (s ProfileIDStatus) String() string {
+	switch s {
+	case ProfileIDMissing:
+		return "missing"
+	case ProfileIDValid:
+		return "valid"
+	case ProfileIDMismatch:
+		return "mismatch"
+	}
+	return fmt.Sprintf("ProfileIDStatus(%d)", int(s))
+}
+
+// Verify the stored profile ID of an ICC profile.
+// A truncated profile is reported as an error.
+func VerifyProfileID(iccProfile []byte) (status ProfileIDStatus, err error) {
+	id, err := ComputeProfileID(iccProfile)
+	if err != nil {
+		return
+	}
+	var stored [16]byte
+	copy(stored[:], iccProfile[84:100])
+	switch stored {
+	case [16]byte{}:
+		return ProfileIDMissing, nil
+	case id:
+		return ProfileIDValid, nil
+	}
+	return ProfileIDMismatch, nil
+}
+
+// an entry of the tag table
+type profileTag struct {
+	Signature string `binary:"[4]byte"` // tag signature
+	Offset    int    `binary:"uint32"`  // offset to the tag data from the start of the profile
+	Size      int    `binary:"uint32"`  // size of the tag data
+}
+
+// the tag table follows the header
+type profileTagTable struct {
+	Count int          `binary:"uint32"`
+	Tag   []profileTag `binary:"[Count]"`
+}
+
+// Profile is a parsed ICC profile.
+// Tag data are not decoded until requested.
+type Profile struct {
+	Header ProfileHeader
+
+	data []byte       // the whole profile
+	tags []profileTag // the tag table
+}
+
+// Parse an ICC profile.
+// The header and the tag table are read and validated; tag data are read lazily.
+func ParseProfile(iccProfile []byte) (profile *Profile, err error) {
+	h, err := ParseProfileHeader(iccProfile)
+	if err != nil {
+		return
+	}
+	if h.Size > len(iccProfile) {
+		err = fmt.Errorf("icc profile truncated")
+		return
+	}
+	if h.Size < profileHeaderSize+4 {
+		err = fmt.Errorf("invalid icc profile size")
+		return
+	}
+	data := iccProfile[:h.Size]
+
+	// read the tag table
+	if n := int(bst.BigEndian.Uint32(data[profileHeaderSize:])); n > (len(data)-profileHeaderSize-4)/12 {
+		err = fmt.Errorf("tag count too large")
+		return
+	}
+	var table profileTagTable
+	_, err = bst.Unmarshal(data[profileHeaderSize:], bst.BigEndian, &table)
+	if err != nil {
+		return
+	}
+	for _, t := range table.Tag {
+		if t.Offset < profileHeaderSize || t.Offset+t.Size > len(data) || t.Offset+t.Size < t.Offset {
+			err = fmt.Errorf("tag %q is out of the profile", t.Signature)
+			return
+		}
+	}
+	return &Profile{Header: *h, data: data, tags: table.Tag}, nil
+}
+
+// VerifyID verifies the stored profile ID.
+func (p *Profile) VerifyID() ProfileIDStatus {
+	status, _ := VerifyProfileID(p.data) // the profile size is already validated
+	return status
+}
+
+// Bytes returns the raw profile data.
+func (p *Profile) Bytes() []byte {
+	return p.data
+}
+
+// Tags returns the signatures of the tags in the profile, in tag table order.
+func (p *Profile) Tags() []string {
+	sigs := make([]string, len(p.tags))
+	for i, t := range p.tags {
+		sigs[i] = t.Signature
+	}
+	return sigs
+}
+
+// HasTag reports whether the profile has a tag with the signature.
+func (p *Profile) HasTag(signature string) bool {
+	for _, t := range p.tags {
+		if t.Signature == signature {
+			return true
+		}
+	}
+	return false
+}
+
+// TagData returns the raw data of a tag. Data shared by multiple tags are returned as is.
+// If there is no such tag then nil data and no error is returned.
+// The returned slice refers to the profile data and must not be modified.
+func (p *Profile) TagData(signature string) (data []byte, err error) {
+	for _, t := range p.tags {
+		if t.Signature == signature {
+			if t.Size < 8 { // tag type signature + reserved
+				err = fmt.Errorf("tag %q too short", signature)
+				return
+			}
+			return p.data[t.Offset : t.Offset+t.Size], nil
+		}
+	}
+	return
+}
+
+// TagType returns the type signature of a tag, the first 4 bytes of the tag data.
+func (p *Profile) TagType(signature string) (typeSignature string, err error) {
+	data, err := p.TagData(signature)
+	if err != nil || data == nil {
+		return
+	}
+	return string(data[:4]), nil
+}
+
+// a tag to be written
+type profileTagData struct {
+	Signature string
+	Data      []byte
+}
+
+// encode an ICC profile of a header and tags.
+// Tags of identical data share the data. Tag data are aligned to 4 bytes.
+// The size in the header is set to the size of the profile.
+func encodeProfile(h *ProfileHeader, tags []profileTagData) (b []byte, err error) {
+	table := profileTagTable{Count: len(tags), Tag: make([]profileTag, len(tags))}
+	var body []byte
+	offset := profileHeaderSize + 4 + 12*len(tags)
+	for i, t := range tags {
+		if len(t.Signature) != 4 {
+			err = fmt.Errorf("invalid tag signature %q", t.Signature)
+			return
+		}
+		table.Tag[i] = profileTag{Signature: t.Signature, Size: len(t.Data)}
+		shared := false
+		for j := 0; j < i; j++ {
+			if bytes.Equal(tags[j].Data, t.Data) {
+				table.Tag[i].Offset = table.Tag[j].Offset
+				shared = true
+				break
+			}
+		}
+		if shared {
+			continue
+		}
+		table.Tag[i].Offset = offset + len(body)
+		body = append(body, t.Data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	hdr := *h
+	hdr.Size = offset + len(body)
+	b, err = encodeProfileHeader(&hdr)
+	if err != nil {
+		return
+	}
+	t, err := bst.Marshal(&table, bst.BigEndian)
+	if err != nil {
+		return
+	}
+	b = append(b, t...)
+	return append(b, body...), nil
+}
@@ -0,0 +1,189 @@
+package imageicc
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// a bare profile header of an RGB display profile
+func testProfileHeader() []byte {
+	b := make([]byte, profileHeaderSize)
+	be := binary.BigEndian
+	be.PutUint32(b[0:], profileHeaderSize)
+	copy(b[4:], "lcms")
+	b[8], b[9] = 4, 0x30 // 4.3.0
+	copy(b[12:], "mntrRGB XYZ ")
+	for i, v := range []uint16{2021, 12, 31, 23, 59, 58} {
+		be.PutUint16(b[24+i*2:], v)
+	}
+	copy(b[36:], "acspAPPL")
+	be.PutUint32(b[44:], FlagEmbedded)
+	copy(b[48:], "mixcmdl ")
+	be.PutUint32(b[64:], uint32(IntentRelativeColorimetric))
+	be.PutUint32(b[68:], 0xf6d6) // D50
+	be.PutUint32(b[72:], 0x10000)
+	be.PutUint32(b[76:], 0xd32d)
+	copy(b[80:], "test")
+	return b
+}
+
+func TestParseProfileHeader(t *testing.T) {
+	h, err := ParseProfileHeader(testProfileHeader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Size != profileHeaderSize || h.PreferredCMM != "lcms" || h.Version.String() != "4.3.0" {
+		t.Errorf("header mismatch: %+v", h)
+	}
+	if h.DeviceClass != ClassDisplay || h.ColorSpace != ColorSpaceRGB || h.PCS != ColorSpaceXYZ {
+		t.Errorf("class or color space mismatch: %+v", h)
+	}
+	if !h.Created.Equal(time.Date(2021, 12, 31, 23, 59, 58, 0, time.UTC)) {
+		t.Errorf("date mismatch: %v", h.Created)
+	}
+	if h.Platform != "APPL" || h.Flags != FlagEmbedded || h.Manufacturer != "mixc" || h.Model != "mdl " || h.Creator != "test" {
+		t.Errorf("header mismatch: %+v", h)
+	}
+	if h.RenderingIntent != IntentRelativeColorimetric {
+		t.Errorf("rendering intent mismatch: %v", h.RenderingIntent)
+	}
+	if h.Illuminant.Y != 1 || h.Illuminant.X < 0.9642 || h.Illuminant.X > 0.9643 {
+		t.Errorf("illuminant mismatch: %+v", h.Illuminant)
+	}
+	if ColorSpaceChannels(h.ColorSpace) != 3 {
+		t.Errorf("channel count mismatch")
+	}
+
+	// broken profiles
+	b := testProfileHeader()
+	copy(b[36:], "xxxx")
+	if _, err = ParseProfileHeader(b); err == nil {
+		t.Errorf("invalid signature not detected")
+	}
+	if _, err = ParseProfileHeader(b[:100]); err == nil {
+		t.Errorf("short profile not detected")
+	}
+}
+
+type testTag struct {
+	sig  string
+	data []byte // nil to share the data of the previous tag
+}
+
+// assemble a profile from the test header and tags
+func testProfileWithTags(tags []testTag) []byte {
+	be := binary.BigEndian
+	b := testProfileHeader()
+	table := make([]byte, 4+12*len(tags))
+	be.PutUint32(table, uint32(len(tags)))
+	b = append(b, table...)
+	var offset, size int
+	for i, t := range tags {
+		if t.data != nil {
+			for len(b)%4 != 0 {
+				b = append(b, 0)
+			}
+			offset, size = len(b), len(t.data)
+			b = append(b, t.data...)
+		}
+		e := b[profileHeaderSize+4+12*i:]
+		copy(e, t.sig)
+		be.PutUint32(e[4:], uint32(offset))
+		be.PutUint32(e[8:], uint32(size))
+	}
+	be.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func TestParseProfile(t *testing.T) {
+	wtpt := []byte("XYZ \x00\x00\x00\x00\x00\x00\xf6\xd6\x00\x01\x00\x00\x00\x00\xd3\x2d")
+	trc := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")
+	b := testProfileWithTags([]testTag{
+		{"wtpt", wtpt},
+		{"rTRC", trc},
+		{"gTRC", nil},
+		{"bTRC", nil},
+	})
+	p, err := ParseProfile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := p.Tags()
+	if len(tags) != 4 || tags[0] != "wtpt" || tags[3] != "bTRC" {
+		t.Fatalf("tag list mismatch: %v", tags)
+	}
+	for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		d, err := p.TagData(sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d) != string(trc) {
+			t.Errorf("shared tag %s mismatch", sig)
+		}
+	}
+	if typ, _ := p.TagType("wtpt"); typ != "XYZ " {
+		t.Errorf("tag type mismatch: %q", typ)
+	}
+	if d, err := p.TagData("kTRC"); d != nil || err != nil || p.HasTag("kTRC") {
+		t.Errorf("non-existing tag returned")
+	}
+
+	// a tag pointing outside of the profile
+	binary.BigEndian.PutUint32(b[profileHeaderSize+4+8:], 0x10000)
+	if _, err = ParseProfile(b); err == nil {
+		t.Errorf("invalid tag offset not detected")
+	}
+	// truncated profile
+	if _, err = ParseProfile(b[:len(b)-1]); err == nil {
+		t.Errorf("truncated profile not detected")
+	}
+}
+
+func TestProfileID(t *testing.T) {
+	b, err := StandardICC(ProfileDisplayP3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := VerifyProfileID(b); err != nil || s != ProfileIDValid {
+		t.Errorf("built-in profile: %v, %v", s, err)
+	}
+
+	// excluded fields do not change the ID
+	m := append([]byte(nil), b...)
+	m[47] |= FlagEmbedded
+	m[67] = byte(IntentSaturation)
+	if s, _ := VerifyProfileID(m); s != ProfileIDValid {
+		t.Errorf("flags and intent changed the profile ID: %v", s)
+	}
+	id, _ := ComputeProfileID(m)
+	if string(id[:]) != string(b[84:100]) {
+		t.Errorf("computed ID %x, stored %x", id, b[84:100])
+	}
+
+	// trailing bytes are ignored
+	if s, _ := VerifyProfileID(append(m, 0, 0, 0, 0)); s != ProfileIDValid {
+		t.Errorf("trailing bytes changed the profile ID: %v", s)
+	}
+
+	// modified
+	m[len(m)-20] ^= 1
+	if s, _ := VerifyProfileID(m); s != ProfileIDMismatch {
+		t.Errorf("modified profile: %v", s)
+	}
+
+	// truncated
+	if _, err := VerifyProfileID(b[:len(b)-100]); err == nil {
+		t.Errorf("truncated profile verified")
+	}
+
+	// no ID
+	b, _ = StandardICC(ProfileSRGB, 2)
+	p, err := ParseProfile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := p.VerifyID(); s != ProfileIDMissing {
+		t.Errorf("v2 profile: %v", s)
+	}
+}
@@ -0,0 +1,230 @@
+//
+// read and write embedded ICC profile in a Photoshop PSD/PSB file
+//
+// Adobe Photoshop file format spec
+// https://www.adobe.com/devnet-apps/photoshop/fileformatashtml/
+//
+
+package imageicc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+var psdSignature = []byte("8BPS") // PSD file signature
+
+// image resource IDs
+const (
+	psdResourceICCProfile  = 0x040f // ICC profile
+	psdResourceICCUntagged = 0x0411 // ICC untagged flag
+)
+
+// the file header of PSD (version 1) and PSB (version 2)
+type psdHeader struct {
+	Signature string `binary:"[4]byte"` // "8BPS"
+	Version   int    `binary:"uint16"`
+	_         int    `binary:"pad(6)"`
+	Channels  int    `binary:"uint16"`
+	Height    int    `binary:"uint32"`
+	Width     int    `binary:"uint32"`
+	Depth     int    `binary:"uint16"`
+	ColorMode int    `binary:"uint16"`
+}
+
+// size of the PSD file header
+const psdHeaderSize = 26
+
+// an image resource block. {Signature, ID, Name, Size, [DATA]}
+type psdResource struct {
+	Signature string // "8BIM", or another signature of a vendor
+	ID        int
+	Name      []byte // Pascal string, including the length byte and padding
+	Data      []byte
+}
+
+// encode an image resource block
+func (res *psdResource) encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(res.Signature)
+	bst.Write(&buf, bst.BigEndian, uint16(res.ID))
+	buf.Write(res.Name)
+	bst.Write(&buf, bst.BigEndian, uint32(len(res.Data)))
+	buf.Write(res.Data)
+	if len(res.Data)&1 != 0 {
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// parse the image resources section
+func parsePSDResources(data []byte) (resources []psdResource, err error) {
+	be := bst.BigEndian
+	for p := 0; p < len(data); {
+		if p+7 > len(data) {
+			err = fmt.Errorf("image resource block too short")
+			return
+		}
+		res := psdResource{Signature: string(data[p : p+4]), ID: int(be.Uint16(data[p+4:]))}
+		switch res.Signature {
+		case "8BIM", "MeSa", "AgHg", "PHUT", "DCSR":
+		default:
+			err = fmt.Errorf("invalid image resource signature %q", res.Signature)
+			return
+		}
+		p += 6
+		nameLen := 1 + int(data[p])
+		nameLen += nameLen & 1 // padded to even
+		if p+nameLen+4 > len(data) {
+			err = fmt.Errorf("image resource block too short")
+			return
+		}
+		res.Name = data[p : p+nameLen]
+		p += nameLen
+		size := int(be.Uint32(data[p:]))
+		p += 4
+		if size > len(data)-p {
+			err = fmt.Errorf("image resource %#04x out of range", res.ID)
+			return
+		}
+		res.Data = data[p : p+size]
+		p += size + size&1
+		resources = append(resources, res)
+	}
+	return
+}
+
+// read the header, the color mode data section and the image resources section
+func readPSDHead(in io.Reader) (head []byte, resources []psdResource, err error) {
+	var h psdHeader
+	var buf bytes.Buffer
+	r := io.TeeReader(in, &buf)
+	_, err = bst.Read(r, bst.BigEndian, &h)
+	if err != nil {
+		return
+	}
+	if h.Signature != string(psdSignature) || (h.Version != 1 && h.Version != 2) {
+		err = fmt.Errorf("invalid PSD header")
+		return
+	}
+
+	// color mode data
+	var size uint32
+	_, err = bst.Read(r, bst.BigEndian, &size)
+	if err != nil {
+		return
+	}
+	_, err = io.CopyN(io.Discard, r, int64(size))
+	if err != nil {
+		return
+	}
+	head = buf.Bytes()
+
+	// image resources
+	_, err = bst.Read(in, bst.BigEndian, &size)
+	if err != nil {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(in, int64(size)))
+	if err != nil {
+		return
+	}
+	if len(data) != int(size) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+	resources, err = parsePSDResources(data)
+	return
+}
+
+// Read ICC profile embedded in a Photoshop PSD or PSB file, from the image resource 0x040F.
+// If there is no ICC profile then nil data and no error is returned.
+func LoadICCfromPSD(in io.Reader) (iccProfile []byte, err error) {
+	_, resources, err := readPSDHead(in)
+	if err != nil {
+		return
+	}
+	for _, res := range resources {
+		if res.Signature == "8BIM" && res.ID == psdResourceICCProfile {
+			return res.Data, nil
+		}
+	}
+	return
+}
+
+// copy a PSD/PSB stream, replacing the ICC profile resource,
+// or removing it if iccProfile is nil.
+func rewritePSDICC(out io.Writer, in io.Reader, iccProfile []byte) (err error) {
+	head, resources, err := readPSDHead(in)
+	if err != nil {
+		return
+	}
+
+	var section bytes.Buffer
+	replaced := false
+	for _, res := range resources {
+		if res.Signature == "8BIM" {
+			switch res.ID {
+			case psdResourceICCProfile:
+				if iccProfile == nil || replaced {
+					continue
+				}
+				res.Data = iccProfile
+				replaced = true
+			case psdResourceICCUntagged:
+				if iccProfile != nil { // the image is no longer untagged
+					continue
+				}
+			}
+		}
+		section.Write(res.encode())
+	}
+	if iccProfile != nil && !replaced {
+		res := psdResource{Signature: "8BIM", ID: psdResourceICCProfile, Name: []byte{0, 0}, Data: iccProfile}
+		section.Write(res.encode())
+	}
+	if int64(section.Len()) > 0xffffffff {
+		err = fmt.Errorf("image resources too large")
+		return
+	}
+
+	_, err = out.Write(head)
+	if err != nil {
+		return
+	}
+	_, err = bst.Write(out, bst.BigEndian, uint32(section.Len()))
+	if err != nil {
+		return
+	}
+	_, err = out.Write(section.Bytes())
+	if err != nil {
+		return
+	}
+	// layer and mask information and image data
+	_, err = io.Copy(out, in)
+	return
+}
+
+// Copy a Photoshop PSD or PSB file from in to out, embedding an ICC profile.
+// An ICC profile resource already in the file is replaced,
+// and the ICC untagged flag resource 0x0411 is removed.
+//
+// StripICCfromPSD is not the inverse: it removes the ICC profile resource only,
+// and keeps the untagged flag resource as is, without adding one.
+func EmbedICCtoPSD(out io.Writer, in io.Reader, iccProfile []byte) (err error) {
+	if len(iccProfile) == 0 {
+		err = fmt.Errorf("empty icc profile")
+		return
+	}
+	return rewritePSDICC(out, in, iccProfile)
+}
+
+// Copy a Photoshop PSD or PSB file from in to out, removing the embedded ICC profile resource 0x040F.
+// The ICC untagged flag resource 0x0411 is kept if present, and is not added otherwise;
+// the flag tells Photoshop not to assign a working space profile, which is up to the caller.
+func StripICCfromPSD(out io.Writer, in io.Reader) (err error) {
+	return rewritePSDICC(out, in, nil)
+}
@@ -0,0 +1,109 @@
+package imageicc
+
+import (
+	"bytes"
+	"testing"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// a PSD (version 1) or PSB (version 2) file with a few image resources
+func testPSD(version int) []byte {
+	be := bst.BigEndian
+	b := []byte("8BPS\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03")
+	b[5] = byte(version)
+	b = append(b, 0, 0, 0, 8, 0, 0, 0, 16, 0, 8, 0, 3) // 16x8, 8 bits, RGB
+	b = append(b, 0, 0, 0, 0)                          // no color mode data
+
+	res := []byte("8BIM\x03\xed\x00\x00\x00\x00\x00\x10") // resolution info
+	res = append(res, make([]byte, 16)...)
+	res = append(res, "8BIM\x04\x11\x02ab\x00\x00\x00\x00\x01\x01\x00"...) // ICC untagged, named
+	b = append(b, 0, 0, 0, 0)
+	be.PutUint32(b[len(b)-4:], uint32(len(res)))
+	b = append(b, res...)
+
+	if version == 1 {
+		b = append(b, 0, 0, 0, 0) // no layer and mask information
+	} else {
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	}
+	b = append(b, 0, 0) // raw image data
+	return append(b, make([]byte, 16*8*3)...)
+}
+
+func TestEmbedICCtoPSD(t *testing.T) {
+	for _, version := range []int{1, 2} {
+		src := testPSD(version)
+		if loaded, err := LoadICCfromPSD(bytes.NewReader(src)); err != nil || loaded != nil {
+			t.Fatalf("profile in the source: %v", err)
+		}
+
+		icc := testProfile(999, 5)
+		var dst bytes.Buffer
+		err := EmbedICCtoPSD(&dst, bytes.NewReader(src), icc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, format, err := LoadICC(bytes.NewReader(dst.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != FormatPSD || !bytes.Equal(loaded, icc) {
+			t.Errorf("v%d: loaded profile differs", version)
+		}
+		if bytes.Contains(dst.Bytes(), []byte("8BIM\x04\x11")) {
+			t.Errorf("v%d: ICC untagged flag not removed", version)
+		}
+		if !bytes.HasSuffix(dst.Bytes(), src[len(src)-16*8*3-2:]) {
+			t.Errorf("v%d: image data not preserved", version)
+		}
+
+		// replace
+		icc2 := testProfile(1000, 6)
+		var dst2 bytes.Buffer
+		err = EmbedICCtoPSD(&dst2, bytes.NewReader(dst.Bytes()), icc2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err = LoadICCfromPSD(bytes.NewReader(dst2.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, icc2) || dst2.Len() != dst.Len() { // 999 bytes are padded to 1000
+			t.Errorf("v%d: profile not replaced", version)
+		}
+
+		// strip
+		var stripped bytes.Buffer
+		err = StripICCfromPSD(&stripped, bytes.NewReader(dst2.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded, err = LoadICCfromPSD(bytes.NewReader(stripped.Bytes())); err != nil || loaded != nil {
+			t.Errorf("v%d: profile not removed: %v", version, err)
+		}
+		// the untagged flag is not added by stripping, nor removed
+		_, resources, err := readPSDHead(bytes.NewReader(stripped.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resources) != 1 || resources[0].ID != 0x03ed {
+			t.Errorf("v%d: resources of the stripped file: %+v", version, resources)
+		}
+		stripped.Reset()
+		err = StripICCfromPSD(&stripped, bytes.NewReader(testPSD(version)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stripped.Bytes(), testPSD(version)) {
+			t.Errorf("v%d: ICC untagged flag changed by stripping", version)
+		}
+	}
+
+	// broken resource
+	b := testPSD(1)
+	b[34] = 'X' // the first resource signature
+	if _, err := LoadICCfromPSD(bytes.NewReader(b)); err == nil {
+		t.Errorf("invalid resource signature accepted")
+	}
+}
@@ -0,0 +1,190 @@
+//
+// built-in ICC profiles of standard color spaces
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"time"
+)
+
+// Chromaticity is a CIE xy chromaticity coordinate.
+type Chromaticity struct {
+	X, Y float64
+}
+
+// XYZ returns the color of the chromaticity with luminance Y = 1.
+func (c Chromaticity) XYZ() XYZ {
+	return XYZ{c.X / c.Y, 1, (1 - c.X - c.Y) / c.Y}
+}
+
+// the D65 illuminant
+var whiteD65 = Chromaticity{0.3127, 0.3290}
+
+// the Bradford cone response matrix
+var bradford = matrix3{
+	0.8951, 0.2664, -0.1614,
+	-0.7502, 1.7135, 0.0367,
+	0.0389, -0.0685, 1.0296,
+}
+
+// chromatic adaptation matrix from a white point to another, by the Bradford transform
+func bradfordAdaptation(src, dst XYZ) (m matrix3, err error) {
+	s := bradford.apply([3]float64{src.X, src.Y, src.Z})
+	d := bradford.apply([3]float64{dst.X, dst.Y, dst.Z})
+	if s[0] == 0 || s[1] == 0 || s[2] == 0 {
+		err = fmt.Errorf("invalid white point")
+		return
+	}
+	scale := matrix3{d[0] / s[0], 0, 0, 0, d[1] / s[1], 0, 0, 0, d[2] / s[2]}
+	inv, err := bradford.inverse()
+	if err != nil {
+		return
+	}
+	m = scale.mul(&bradford)
+	return inv.mul(&m), nil
+}
+
+// colorants of RGB primaries and a white point, adapted to the PCS white
+func rgbColorants(primaries [3]Chromaticity, white XYZ) (colorants [3]XYZ, err error) {
+	for _, p := range primaries {
+		if p.Y <= 0 {
+			err = fmt.Errorf("invalid primary chromaticity %v", p)
+			return
+		}
+	}
+	r, g, b := primaries[0].XYZ(), primaries[1].XYZ(), primaries[2].XYZ()
+	m := matrix3{r.X, g.X, b.X, r.Y, g.Y, b.Y, r.Z, g.Z, b.Z}
+	inv, err := m.inverse()
+	if err != nil {
+		return
+	}
+	// scale the primaries so that RGB(1, 1, 1) is the white
+	s := inv.apply([3]float64{white.X, white.Y, white.Z})
+	adapt, err := bradfordAdaptation(white, pcsWhite)
+	if err != nil {
+		return
+	}
+	for i, p := range []XYZ{r, g, b} {
+		v := adapt.apply([3]float64{p.X * s[i], p.Y * s[i], p.Z * s[i]})
+		colorants[i] = XYZ{v[0], v[1], v[2]}
+	}
+	return
+}
+
+// StandardProfile is a built-in profile of a standard color space.
+type StandardProfile int
+
+const (
+	ProfileSRGB        StandardProfile = iota // sRGB IEC61966-2.1
+	ProfileDisplayP3                          // Display P3; DCI-P3 primaries, D65 white and the sRGB curve
+	ProfileAdobeRGB                           // Adobe RGB (1998)
+	ProfileProPhotoRGB                        // ProPhoto RGB, ROMM RGB
+	ProfileRec2020                            // ITU-R BT.2020
+	ProfileGray22                             // gray, gamma 2.2 with D65 white
+	ProfileGraySRGB                           // gray, the sRGB curve with D65 white
+)
+
+// definition of a standard profile
+type standardProfileDef struct {
+	description string
+	colorSpace  string
+	primaries   [3]Chromaticity // RGB only
+	white       Chromaticity    // D50 if zero
+	curve       Curve
+}
+
+// the sRGB tone curve
+var srgbCurve = ParametricCurve{Function: 3, Params: []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}}
+
+// the BT.709/BT.2020 tone curve, inverse of the OETF
+var rec709Curve = ParametricCurve{Function: 3, Params: []float64{1 / 0.45, 1 / 1.099, 0.099 / 1.099, 1 / 4.5, 0.081}}
+
+var standardProfiles = map[StandardProfile]standardProfileDef{
+	ProfileSRGB: {
+		description: "sRGB IEC61966-2.1",
+		colorSpace:  ColorSpaceRGB,
+		primaries:   [3]Chromaticity{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}},
+		white:       whiteD65,
+		curve:       srgbCurve,
+	},
+	ProfileDisplayP3: {
+		description: "Display P3",
+		colorSpace:  ColorSpaceRGB,
+		primaries:   [3]Chromaticity{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}},
+		white:       whiteD65,
+		curve:       srgbCurve,
+	},
+	ProfileAdobeRGB: {
+		description: "Adobe RGB (1998)",
+		colorSpace:  ColorSpaceRGB,
+		primaries:   [3]Chromaticity{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}},
+		white:       whiteD65,
+		curve:       GammaCurve(563.0 / 256),
+	},
+	ProfileProPhotoRGB: {
+		description: "ProPhoto RGB",
+		colorSpace:  ColorSpaceRGB,
+		primaries:   [3]Chromaticity{{0.7347, 0.2653}, {0.1596, 0.8404}, {0.0366, 0.0001}},
+		curve:       GammaCurve(1.8),
+	},
+	ProfileRec2020: {
+		description: "ITU-R BT.2020",
+		colorSpace:  ColorSpaceRGB,
+		primaries:   [3]Chromaticity{{0.708, 0.292}, {0.170, 0.797}, {0.131, 0.046}},
+		white:       whiteD65,
+		curve:       rec709Curve,
+	},
+	ProfileGray22: {
+		description: "Gray Gamma 2.2",
+		colorSpace:  ColorSpaceGray,
+		white:       whiteD65,
+		curve:       GammaCurve(2.2),
+	},
+	ProfileGraySRGB: {
+		description: "Gray sRGB TRC",
+		colorSpace:  ColorSpaceGray,
+		white:       whiteD65,
+		curve:       srgbCurve,
+	},
+}
+
+func (sp StandardProfile) String() string {
+	if d, ok := standardProfiles[sp]; ok {
+		return d.description
+	}
+	return fmt.Sprintf("StandardProfile(%d)", int(sp))
+}
+
+// copyright of built-in profiles
+const standardProfileCopyright = "No copyright, use freely"
+
+// creation date of built-in profiles, fixed so that the profiles are reproducible
+var standardProfileDate = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// StandardICC returns a built-in ICC profile of a standard color space.
+// version is the major version of the profile, either 2 or 4.
+// The profiles are generated, and the same bytes are returned for the same arguments.
+func StandardICC(sp StandardProfile, version int) (iccProfile []byte, err error) {
+	def, ok := standardProfiles[sp]
+	if !ok {
+		err = fmt.Errorf("unknown standard profile %d", int(sp))
+		return
+	}
+	if version != 2 && version != 4 {
+		err = fmt.Errorf("unsupported profile version %d", version)
+		return
+	}
+	pb := &ProfileBuilder{
+		Version:     version,
+		ColorSpace:  def.colorSpace,
+		Primaries:   def.primaries,
+		White:       def.white,
+		Curves:      []Curve{def.curve},
+		Description: def.description,
+		Copyright:   standardProfileCopyright,
+		Created:     standardProfileDate,
+	}
+	return pb.Build()
+}
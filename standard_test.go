@@ -0,0 +1,76 @@
+package imageicc
+
+import (
+	"testing"
+)
+
+func TestStandardICC(t *testing.T) {
+	all := []StandardProfile{
+		ProfileSRGB, ProfileDisplayP3, ProfileAdobeRGB, ProfileProPhotoRGB, ProfileRec2020,
+		ProfileGray22, ProfileGraySRGB,
+	}
+	for _, sp := range all {
+		for _, version := range []int{2, 4} {
+			b, err := StandardICC(sp, version)
+			if err != nil {
+				t.Fatalf("%v v%d: %v", sp, version, err)
+			}
+			again, _ := StandardICC(sp, version)
+			if string(b) != string(again) {
+				t.Errorf("%v v%d: output not reproducible", sp, version)
+			}
+			p, err := ParseProfile(b)
+			if err != nil {
+				t.Fatalf("%v v%d: %v", sp, version, err)
+			}
+			if p.Header.Size != len(b) || len(b)%4 != 0 || p.Header.Version.Major != version {
+				t.Errorf("%v v%d: size %d, len %d, version %v", sp, version, p.Header.Size, len(b), p.Header.Version)
+			}
+			if d, err := p.Description(); err != nil || d != sp.String() {
+				t.Errorf("%v v%d: description %q, %v", sp, version, d, err)
+			}
+			if c, err := p.Copyright(); err != nil || c == "" {
+				t.Errorf("%v v%d: copyright %q, %v", sp, version, c, err)
+			}
+			if version == 4 && !p.HasTag(TagChromaticAdapt) {
+				t.Errorf("%v v4: no chad tag", sp)
+			}
+		}
+	}
+
+	// colorants of sRGB, adapted to D50
+	p, err := StandardICC(ProfileSRGB, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srgb, _ := ParseProfile(p)
+	r, g, b, err := srgb.Colorants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range [][2]XYZ{{r, {0.4361, 0.2225, 0.0139}}, {g, {0.3851, 0.7169, 0.0971}}, {b, {0.1431, 0.0606, 0.7141}}} {
+		if !nearColor([]float64{c[0].X, c[0].Y, c[0].Z}, []float64{c[1].X, c[1].Y, c[1].Z}, 2e-4) {
+			t.Errorf("sRGB colorant %d: %v, want %v", i, c[0], c[1])
+		}
+	}
+
+	// v2 profiles have sampled curves; conversion between versions is near identity
+	for _, sp := range all {
+		v2, _ := StandardICC(sp, 2)
+		v4, _ := StandardICC(sp, 4)
+		p2, _ := ParseProfile(v2)
+		p4, _ := ParseProfile(v4)
+		tr, err := NewTransform(p4, p2)
+		if err != nil {
+			t.Fatalf("%v: %v", sp, err)
+		}
+		in := []float64{0.25, 0.5, 0.75}[:tr.InputChannels()]
+		if out := tr.Convert(in); !nearColor(out, in, 2e-3) {
+			t.Errorf("%v: v4 to v2 %v -> %v", sp, in, out)
+		}
+	}
+
+	if _, err := StandardICC(ProfileSRGB, 3); err == nil {
+		t.Errorf("version 3 accepted")
+	}
+}
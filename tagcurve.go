@@ -0,0 +1,393 @@
+//
+// decode and encode XYZ and curve tag types of ICC profiles
+//   XYZType 'XYZ ', curveType 'curv', parametricCurveType 'para' and s15Fixed16ArrayType 'sf32'
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"math"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// colorimetric tag signatures
+const (
+	TagMediaWhitePoint = "wtpt" // media white point
+	TagMediaBlackPoint = "bkpt" // media black point (v2)
+	TagRedColorant     = "rXYZ" // red matrix column
+	TagGreenColorant   = "gXYZ" // green matrix column
+	TagBlueColorant    = "bXYZ" // blue matrix column
+	TagRedTRC          = "rTRC" // red tone reproduction curve
+	TagGreenTRC        = "gTRC" // green tone reproduction curve
+	TagBlueTRC         = "bTRC" // blue tone reproduction curve
+	TagGrayTRC         = "kTRC" // gray tone reproduction curve
+	TagChromaticAdapt  = "chad" // chromatic adaptation matrix (v4)
+)
+
+// curve tag type signatures
+const (
+	tagTypeXYZ        = "XYZ " // XYZType
+	tagTypeCurve      = "curv" // curveType
+	tagTypeParametric = "para" // parametricCurveType
+	tagTypeS15f16     = "sf32" // s15Fixed16ArrayType
+)
+
+// number of samples of a curve encoded as a curveType table
+const curveTableSize = 1024
+
+// Curve is a one-dimensional transfer function mapping [0, 1] to [0, 1].
+type Curve interface {
+	Eval(x float64) float64
+}
+
+// GammaCurve is a simple power function, y = x^gamma.
+// An identity curve is GammaCurve(1).
+type GammaCurve float64
+
+func (g GammaCurve) Eval(x float64) float64 {
+	return math.Pow(clamp01(x), float64(g))
+}
+
+// SampledCurve is a curve of evenly spaced samples over [0, 1], linearly interpolated.
+type SampledCurve []float64
+
+func (c SampledCurve) Eval(x float64) float64 {
+	return interpolateTable(c, clamp01(x))
+}
+
+// ParametricCurve is a parametric curve of the ICC parametricCurveType.
+// Params are {g, a, b, c, d, e, f} in the order of the spec, truncated to the number used by Function.
+//
+//	Function 0: y = x^g
+//	Function 1: y = (ax+b)^g        if x >= -b/a, 0 otherwise
+//	Function 2: y = (ax+b)^g + c    if x >= -b/a, c otherwise
+//	Function 3: y = (ax+b)^g        if x >= d, cx otherwise
+//	Function 4: y = (ax+b)^g + e    if x >= d, cx+f otherwise
+//
+// A curve of an unknown Function, or with fewer Params than the Function uses, evaluates as the identity.
+type ParametricCurve struct {
+	Function int
+	Params   []float64
+}
+
+// number of parameters of parametric functions
+var parametricParamCount = []int{1, 3, 4, 5, 7}
+
+func (c ParametricCurve) Eval(x float64) float64 {
+	x = clamp01(x)
+	if c.Function < 0 || c.Function >= len(parametricParamCount) || len(c.Params) < parametricParamCount[c.Function] {
+		return x
+	}
+	p := c.Params
+	pow := func(v, g float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, g)
+	}
+	var y float64
+	switch c.Function {
+	case 0:
+		y = pow(x, p[0])
+	case 1:
+		if x >= -p[2]/p[1] {
+			y = pow(p[1]*x+p[2], p[0])
+		}
+	case 2:
+		if x >= -p[2]/p[1] {
+			y = pow(p[1]*x+p[2], p[0]) + p[3]
+		} else {
+			y = p[3]
+		}
+	case 3:
+		if x >= p[4] {
+			y = pow(p[1]*x+p[2], p[0])
+		} else {
+			y = p[3] * x
+		}
+	case 4:
+		if x >= p[4] {
+			y = pow(p[1]*x+p[2], p[0]) + p[5]
+		} else {
+			y = p[3]*x + p[6]
+		}
+	}
+	return clamp01(y)
+}
+
+// clamp a value to [0, 1]
+func clamp01(x float64) float64 {
+	if x < 0 || math.IsNaN(x) {
+		return 0
+	}
+	if x > 1 {
+		return 1
+	}
+	return x
+}
+
+// linearly interpolate a table of evenly spaced samples at x in [0, 1]
+func interpolateTable(t []float64, x float64) float64 {
+	switch len(t) {
+	case 0:
+		return x
+	case 1:
+		return t[0]
+	}
+	pos := x * float64(len(t)-1)
+	i := int(pos)
+	if i >= len(t)-1 {
+		return t[len(t)-1]
+	}
+	f := pos - float64(i)
+	return t[i] + (t[i+1]-t[i])*f
+}
+
+// decode a XYZType into XYZ values
+func decodeXYZ(data []byte) (xyz []XYZ, err error) {
+	if len(data) < 8 || string(data[:4]) != tagTypeXYZ {
+		err = fmt.Errorf("not a XYZType")
+		return
+	}
+	be := bst.BigEndian
+	xyz = make([]XYZ, (len(data)-8)/12)
+	for i := range xyz {
+		v := data[8+i*12:]
+		xyz[i] = XYZ{
+			s15f16ToFloat(int32(be.Uint32(v[0:]))),
+			s15f16ToFloat(int32(be.Uint32(v[4:]))),
+			s15f16ToFloat(int32(be.Uint32(v[8:]))),
+		}
+	}
+	return
+}
+
+// encode XYZ values into a XYZType
+func encodeXYZ(xyz ...XYZ) []byte {
+	be := bst.BigEndian
+	b := make([]byte, 8+12*len(xyz))
+	copy(b, tagTypeXYZ)
+	for i, v := range xyz {
+		be.PutUint32(b[8+i*12:], uint32(floatToS15f16(v.X)))
+		be.PutUint32(b[12+i*12:], uint32(floatToS15f16(v.Y)))
+		be.PutUint32(b[16+i*12:], uint32(floatToS15f16(v.Z)))
+	}
+	return b
+}
+
+// encode numbers into a s15Fixed16ArrayType
+func encodeS15f16Array(v []float64) []byte {
+	b := make([]byte, 8+4*len(v))
+	copy(b, tagTypeS15f16)
+	for i, f := range v {
+		bst.BigEndian.PutUint32(b[8+i*4:], uint32(floatToS15f16(f)))
+	}
+	return b
+}
+
+// encode a curve.
+// If parametric is set then gamma and parametric curves are encoded as a parametricCurveType,
+// which is defined in v4 profiles; otherwise all curves are encoded as a curveType.
+func encodeCurve(curve Curve, parametric bool) (data []byte, err error) {
+	be := bst.BigEndian
+	var table SampledCurve
+	switch c := curve.(type) {
+	case GammaCurve:
+		switch {
+		case parametric:
+			return encodeCurve(ParametricCurve{Function: 0, Params: []float64{float64(c)}}, true)
+		case c == 1:
+			data = make([]byte, 12)
+			copy(data, tagTypeCurve)
+			return
+		}
+		g := math.Round(float64(c) * 256)
+		if g < 0 || g > 0xffff {
+			err = fmt.Errorf("gamma %g out of range", float64(c))
+			return
+		}
+		data = make([]byte, 14)
+		copy(data, tagTypeCurve)
+		be.PutUint32(data[8:], 1)
+		be.PutUint16(data[12:], uint16(g))
+		return
+
+	case ParametricCurve:
+		if c.Function < 0 || c.Function >= len(parametricParamCount) || len(c.Params) != parametricParamCount[c.Function] {
+			err = fmt.Errorf("invalid parametric curve")
+			return
+		}
+		if !parametric {
+			break
+		}
+		data = make([]byte, 12+4*len(c.Params))
+		copy(data, tagTypeParametric)
+		be.PutUint16(data[8:], uint16(c.Function))
+		for i, v := range c.Params {
+			be.PutUint32(data[12+i*4:], uint32(floatToS15f16(v)))
+		}
+		return
+
+	case SampledCurve:
+		table = c
+	}
+
+	if table == nil {
+		table = make(SampledCurve, curveTableSize)
+		for i := range table {
+			table[i] = curve.Eval(float64(i) / float64(curveTableSize-1))
+		}
+	}
+	data = make([]byte, 12+2*len(table))
+	copy(data, tagTypeCurve)
+	be.PutUint32(data[8:], uint32(len(table)))
+	for i, v := range table {
+		be.PutUint16(data[12+i*2:], uint16(math.Round(clamp01(v)*65535)))
+	}
+	return
+}
+
+// decode a curveType or a parametricCurveType.
+// n is the byte size of the curve, excluding padding.
+func decodeCurve(data []byte) (curve Curve, n int, err error) {
+	if len(data) < 12 {
+		err = fmt.Errorf("curve too short")
+		return
+	}
+	be := bst.BigEndian
+	switch string(data[:4]) {
+	case tagTypeCurve:
+		count := int(be.Uint32(data[8:]))
+		n = 12 + count*2
+		if count < 0 || n > len(data) || n < 12 {
+			err = fmt.Errorf("curveType too short")
+			return
+		}
+		switch count {
+		case 0: // identity
+			curve = GammaCurve(1)
+		case 1: // u8Fixed8Number gamma
+			curve = GammaCurve(float64(be.Uint16(data[12:])) / 256)
+		default:
+			t := make(SampledCurve, count)
+			for i := range t {
+				t[i] = float64(be.Uint16(data[12+i*2:])) / 65535
+			}
+			curve = t
+		}
+
+	case tagTypeParametric:
+		function := int(be.Uint16(data[8:]))
+		if function >= len(parametricParamCount) {
+			err = fmt.Errorf("unknown parametric function type %d", function)
+			return
+		}
+		count := parametricParamCount[function]
+		n = 12 + count*4
+		if n > len(data) {
+			err = fmt.Errorf("parametricCurveType too short")
+			return
+		}
+		params := make([]float64, count)
+		for i := range params {
+			params[i] = s15f16ToFloat(int32(be.Uint32(data[12+i*4:])))
+		}
+		if function >= 1 && function <= 2 && params[1] == 0 {
+			err = fmt.Errorf("invalid parametric curve")
+			return
+		}
+		curve = ParametricCurve{Function: function, Params: params}
+
+	default:
+		err = fmt.Errorf("not a curve type")
+	}
+	return
+}
+
+// TagXYZ decodes a tag of XYZType and returns its first value.
+func (p *Profile) TagXYZ(signature string) (xyz XYZ, err error) {
+	data, err := p.TagData(signature)
+	if err != nil {
+		return
+	}
+	if data == nil {
+		err = fmt.Errorf("tag %q not found", signature)
+		return
+	}
+	l, err := decodeXYZ(data)
+	if err != nil {
+		return
+	}
+	if len(l) == 0 {
+		err = fmt.Errorf("tag %q has no value", signature)
+		return
+	}
+	return l[0], nil
+}
+
+// TagCurve decodes a tag of curveType or parametricCurveType.
+func (p *Profile) TagCurve(signature string) (curve Curve, err error) {
+	data, err := p.TagData(signature)
+	if err != nil {
+		return
+	}
+	if data == nil {
+		err = fmt.Errorf("tag %q not found", signature)
+		return
+	}
+	curve, _, err = decodeCurve(data)
+	return
+}
+
+// MediaWhitePoint returns the media white point, 'wtpt'.
+func (p *Profile) MediaWhitePoint() (XYZ, error) {
+	return p.TagXYZ(TagMediaWhitePoint)
+}
+
+// MediaBlackPoint returns the media black point, 'bkpt'.
+// The tag is defined in v2 profiles only; zero is returned if there is no such tag.
+func (p *Profile) MediaBlackPoint() (xyz XYZ, err error) {
+	if !p.HasTag(TagMediaBlackPoint) {
+		return
+	}
+	return p.TagXYZ(TagMediaBlackPoint)
+}
+
+// Colorants returns the red, green and blue colorants, which are the columns of the RGB to PCS matrix.
+func (p *Profile) Colorants() (r, g, b XYZ, err error) {
+	r, err = p.TagXYZ(TagRedColorant)
+	if err != nil {
+		return
+	}
+	g, err = p.TagXYZ(TagGreenColorant)
+	if err != nil {
+		return
+	}
+	b, err = p.TagXYZ(TagBlueColorant)
+	return
+}
+
+// ToneCurves returns the tone reproduction curves of the profile,
+// rTRC/gTRC/bTRC for RGB profiles or kTRC for gray profiles.
+func (p *Profile) ToneCurves() (curves []Curve, err error) {
+	var sigs []string
+	switch p.Header.ColorSpace {
+	case ColorSpaceRGB:
+		sigs = []string{TagRedTRC, TagGreenTRC, TagBlueTRC}
+	case ColorSpaceGray:
+		sigs = []string{TagGrayTRC}
+	default:
+		err = fmt.Errorf("no tone curves for color space %q", p.Header.ColorSpace)
+		return
+	}
+	curves = make([]Curve, len(sigs))
+	for i, sig := range sigs {
+		curves[i], err = p.TagCurve(sig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return
+}
@@ -0,0 +1,120 @@
+package imageicc
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// s15Fixed16Number bytes
+func testS15f16(v ...float64) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.BigEndian.PutUint32(b[i*4:], uint32(int32(math.Round(f*65536))))
+	}
+	return b
+}
+
+// XYZType tag data
+func testXYZTag(x, y, z float64) []byte {
+	return append([]byte("XYZ \x00\x00\x00\x00"), testS15f16(x, y, z)...)
+}
+
+// parametricCurveType tag data
+func testParaTag(function int, params ...float64) []byte {
+	b := []byte("para\x00\x00\x00\x00\x00\x00\x00\x00")
+	b[9] = byte(function)
+	return append(b, testS15f16(params...)...)
+}
+
+// the sRGB transfer function as parameters of the parametric function 3
+var testSRGBParams = []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}
+
+func TestProfileCurves(t *testing.T) {
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-4 }
+
+	p, err := ParseProfile(testProfileWithTags([]testTag{
+		{"wtpt", testXYZTag(0.9642, 1, 0.8249)},
+		{"rXYZ", testXYZTag(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", testXYZTag(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", testXYZTag(0.1431, 0.0606, 0.7141)},
+		{"rTRC", testParaTag(3, testSRGBParams...)},
+		{"gTRC", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33")},                 // gamma 2.2
+		{"bTRC", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x40\x00\xff\xff")}, // sampled
+		{"kTRC", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")},                         // identity
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wp, err := p.MediaWhitePoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(wp.X, 0.9642) || !near(wp.Y, 1) || !near(wp.Z, 0.8249) {
+		t.Errorf("white point mismatch: %v", wp)
+	}
+	if bp, err := p.MediaBlackPoint(); err != nil || bp != (XYZ{}) {
+		t.Errorf("black point mismatch: %v, %v", bp, err)
+	}
+	r, g, b, err := p.Colorants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(r.X+g.X+b.X, wp.X) || !near(r.Y+g.Y+b.Y, 1) {
+		t.Errorf("colorants mismatch: %v %v %v", r, g, b)
+	}
+
+	curves, err := p.ToneCurves()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(curves) != 3 {
+		t.Fatalf("curve count mismatch")
+	}
+	if y := curves[0].Eval(0.5); !near(y, 0.214041) {
+		t.Errorf("sRGB curve mismatch: %f", y)
+	}
+	if y := curves[0].Eval(0.02); !near(y, 0.02/12.92) {
+		t.Errorf("sRGB curve linear segment mismatch: %f", y)
+	}
+	if y := curves[1].Eval(0.5); !near(y, math.Pow(0.5, 2.19921875)) {
+		t.Errorf("gamma curve mismatch: %f", y)
+	}
+	if y := curves[2].Eval(0.25); !near(y, 0.125) {
+		t.Errorf("sampled curve mismatch: %f", y)
+	}
+	if k, err := p.TagCurve("kTRC"); err != nil || k.Eval(0.3) != 0.3 {
+		t.Errorf("identity curve mismatch")
+	}
+
+	// all parametric function types
+	for _, tc := range []struct {
+		function int
+		params   []float64
+		x, y     float64
+	}{
+		{0, []float64{2}, 0.5, 0.25},
+		{1, []float64{2, 1, -0.5}, 0.25, 0},
+		{1, []float64{2, 1, -0.5}, 0.75, 0.0625},
+		{2, []float64{2, 1, -0.5, 0.1}, 0.25, 0.1},
+		{3, []float64{1, 1, 0, 2, 0.5}, 0.25, 0.5},
+		{4, []float64{1, 1, 0, 2, 0.5, 0.1, 0.2}, 0.25, 0.7},
+		{4, []float64{1, 1, 0, 2, 0.5, 0.1, 0.2}, 0.75, 0.85},
+	} {
+		c, _, err := decodeCurve(testParaTag(tc.function, tc.params...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if y := c.Eval(tc.x); !near(y, tc.y) {
+			t.Errorf("parametric function %d mismatch at %f: %f", tc.function, tc.x, y)
+		}
+	}
+
+	// invalid curves evaluate as the identity
+	for _, c := range []ParametricCurve{{Function: 4, Params: []float64{1, 1, 0}}, {Function: 3}, {Function: 5, Params: []float64{2}}, {Function: -1}} {
+		if y := c.Eval(0.3); y != 0.3 {
+			t.Errorf("invalid curve %v: %f", c, y)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+//
+// decode LUT-based tag types of ICC profiles
+//   lut8Type 'mft1', lut16Type 'mft2', lutAToBType 'mAB ' and lutBToAType 'mBA '
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"strings"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// LUT tag signatures
+const (
+	TagAToB0 = "A2B0" // device to PCS, perceptual
+	TagAToB1 = "A2B1" // device to PCS, colorimetric
+	TagAToB2 = "A2B2" // device to PCS, saturation
+	TagBToA0 = "B2A0" // PCS to device, perceptual
+	TagBToA1 = "B2A1" // PCS to device, colorimetric
+	TagBToA2 = "B2A2" // PCS to device, saturation
+	TagGamut = "gamt" // out of gamut
+)
+
+// LUT tag type signatures
+const (
+	tagTypeLut8  = "mft1" // lut8Type
+	tagTypeLut16 = "mft2" // lut16Type
+	tagTypeLutAB = "mAB " // lutAToBType
+	tagTypeLutBA = "mBA " // lutBToAType
+)
+
+// CLUT is a multidimensional color lookup table.
+// Values are normalized to [0, 1] and stored with the first input channel varying slowest.
+type CLUT struct {
+	GridPoints     []int // number of grid points of each input channel
+	OutputChannels int
+	Values         []float64
+}
+
+// Lut is a decoded LUT-based tag.
+// Pipeline elements that are absent in the tag are nil.
+// All values are normalized to [0, 1].
+//
+// Elements are evaluated in the following order:
+//
+//	mft1, mft2: Matrix -> ACurves -> CLUT -> BCurves
+//	mAB:        ACurves -> CLUT -> MCurves -> Matrix -> BCurves
+//	mBA:        BCurves -> Matrix -> MCurves -> CLUT -> ACurves
+//
+// For mft1 and mft2, ACurves and BCurves are the input and output tables respectively,
+// and Matrix is nil unless the input color space of the tag is XYZ.
+type Lut struct {
+	Type           string // tag type signature
+	InputChannels  int
+	OutputChannels int
+
+	// 3x3 matrix in row-major order, followed by 3 offsets for mAB/mBA
+	Matrix []float64
+
+	ACurves, BCurves, MCurves []Curve
+	CLUT                      *CLUT
+}
+
+// read a 3x3 matrix of s15Fixed16Numbers, optionally followed by offsets
+func decodeMatrix(data []byte, n int) (m []float64, err error) {
+	if len(data) < n*4 {
+		err = fmt.Errorf("matrix too short")
+		return
+	}
+	m = make([]float64, n)
+	for i := range m {
+		m[i] = s15f16ToFloat(int32(bst.BigEndian.Uint32(data[i*4:])))
+	}
+	return
+}
+
+// whether a 3x3 matrix is an identity matrix
+func isIdentityMatrix(m []float64) bool {
+	for i := 0; i < 9; i++ {
+		want := 0.0
+		if i%4 == 0 {
+			want = 1
+		}
+		if m[i] != want {
+			return false
+		}
+	}
+	return true
+}
+
+// read a sequence of curves, each padded to a 4-byte boundary
+func decodeCurves(data []byte, count int) (curves []Curve, err error) {
+	curves = make([]Curve, count)
+	pos := 0
+	for i := range curves {
+		if pos > len(data) {
+			err = fmt.Errorf("curves too short")
+			return
+		}
+		var n int
+		curves[i], n, err = decodeCurve(data[pos:])
+		if err != nil {
+			return
+		}
+		pos += (n + 3) &^ 3
+	}
+	return
+}
+
+// read 8-bit or 16-bit tables of mft1/mft2
+func decodeLutTables(data []byte, count, entries, precision int) (curves []Curve, err error) {
+	if len(data) < count*entries*precision {
+		err = fmt.Errorf("lut tables too short")
+		return
+	}
+	curves = make([]Curve, count)
+	for i := range curves {
+		t := make(SampledCurve, entries)
+		readLutValues(data[i*entries*precision:], precision, t)
+		curves[i] = t
+	}
+	return
+}
+
+// read normalized 8-bit or 16-bit values
+func readLutValues(data []byte, precision int, v []float64) {
+	if precision == 1 {
+		for i := range v {
+			v[i] = float64(data[i]) / 255
+		}
+		return
+	}
+	for i := range v {
+		v[i] = float64(bst.BigEndian.Uint16(data[i*2:])) / 65535
+	}
+}
+
+// read a CLUT of a given grid
+func decodeCLUTValues(data []byte, gridPoints []int, outputChannels, precision int) (clut *CLUT, err error) {
+	n := outputChannels
+	for _, g := range gridPoints {
+		if g < 2 {
+			err = fmt.Errorf("invalid CLUT grid")
+			return
+		}
+		n *= g
+		if n > len(data) {
+			err = fmt.Errorf("CLUT too short")
+			return
+		}
+	}
+	if n*precision > len(data) {
+		err = fmt.Errorf("CLUT too short")
+		return
+	}
+	clut = &CLUT{GridPoints: gridPoints, OutputChannels: outputChannels, Values: make([]float64, n)}
+	readLutValues(data, precision, clut.Values)
+	return
+}
+
+// decode a lut8Type or lut16Type.
+// The matrix is used only if inputXYZ is set, as the spec applies it to XYZ input only.
+func decodeLutMFT(data []byte, inputXYZ bool) (lut *Lut, err error) {
+	if len(data) < 48 {
+		err = fmt.Errorf("lut too short")
+		return
+	}
+	lut = &Lut{
+		Type:           string(data[:4]),
+		InputChannels:  int(data[8]),
+		OutputChannels: int(data[9]),
+	}
+	grid := int(data[10])
+	if lut.InputChannels == 0 || lut.OutputChannels == 0 || lut.InputChannels > 15 || lut.OutputChannels > 15 {
+		err = fmt.Errorf("invalid lut channel count")
+		return
+	}
+	lut.Matrix, err = decodeMatrix(data[12:], 9)
+	if err != nil {
+		return
+	}
+	if !inputXYZ || isIdentityMatrix(lut.Matrix) {
+		lut.Matrix = nil
+	} else if lut.InputChannels != 3 {
+		err = fmt.Errorf("lut matrix requires 3 input channels")
+		return
+	}
+
+	precision, inEntries, outEntries, pos := 1, 256, 256, 48
+	if lut.Type == tagTypeLut16 {
+		if len(data) < 52 {
+			err = fmt.Errorf("lut too short")
+			return
+		}
+		precision, pos = 2, 52
+		inEntries = int(bst.BigEndian.Uint16(data[48:]))
+		outEntries = int(bst.BigEndian.Uint16(data[50:]))
+		if inEntries < 2 || outEntries < 2 {
+			err = fmt.Errorf("invalid lut table size")
+			return
+		}
+	}
+
+	// input tables
+	lut.ACurves, err = decodeLutTables(data[pos:], lut.InputChannels, inEntries, precision)
+	if err != nil {
+		return
+	}
+	pos += lut.InputChannels * inEntries * precision
+
+	// CLUT
+	gridPoints := make([]int, lut.InputChannels)
+	for i := range gridPoints {
+		gridPoints[i] = grid
+	}
+	lut.CLUT, err = decodeCLUTValues(data[pos:], gridPoints, lut.OutputChannels, precision)
+	if err != nil {
+		return
+	}
+	pos += len(lut.CLUT.Values) * precision
+
+	// output tables
+	lut.BCurves, err = decodeLutTables(data[pos:], lut.OutputChannels, outEntries, precision)
+	return
+}
+
+// decode a lutAToBType or lutBToAType
+func decodeLutAB(data []byte) (lut *Lut, err error) {
+	var h struct {
+		Type           string `binary:"[4]byte"`
+		Reserved       uint32
+		InputChannels  int `binary:"uint8"`
+		OutputChannels int `binary:"uint8"`
+		Padding        int `binary:"pad(2)"`
+		OffsetB        int `binary:"uint32"`
+		OffsetMatrix   int `binary:"uint32"`
+		OffsetM        int `binary:"uint32"`
+		OffsetCLUT     int `binary:"uint32"`
+		OffsetA        int `binary:"uint32"`
+	}
+	if len(data) < 32 {
+		err = fmt.Errorf("lut too short")
+		return
+	}
+	_, err = bst.Unmarshal(data[:32], bst.BigEndian, &h)
+	if err != nil {
+		return
+	}
+	lut = &Lut{Type: h.Type, InputChannels: h.InputChannels, OutputChannels: h.OutputChannels}
+	if lut.InputChannels == 0 || lut.OutputChannels == 0 || lut.InputChannels > 15 || lut.OutputChannels > 15 {
+		err = fmt.Errorf("invalid lut channel count")
+		return
+	}
+	for _, o := range []int{h.OffsetB, h.OffsetMatrix, h.OffsetM, h.OffsetCLUT, h.OffsetA} {
+		if o != 0 && (o < 32 || o >= len(data)) {
+			err = fmt.Errorf("lut element out of range")
+			return
+		}
+	}
+
+	// channel counts of the elements; A is on the device side and B is on the PCS side
+	aCount, bCount := lut.InputChannels, lut.OutputChannels
+	clutIn, clutOut := lut.InputChannels, lut.OutputChannels
+	if lut.Type == tagTypeLutBA {
+		aCount, bCount = lut.OutputChannels, lut.InputChannels
+	}
+	if h.OffsetB == 0 {
+		err = fmt.Errorf("lut has no B curves")
+		return
+	}
+	lut.BCurves, err = decodeCurves(data[h.OffsetB:], bCount)
+	if err != nil {
+		return
+	}
+	if h.OffsetMatrix != 0 {
+		lut.Matrix, err = decodeMatrix(data[h.OffsetMatrix:], 12)
+		if err != nil {
+			return
+		}
+	}
+	if h.OffsetM != 0 {
+		lut.MCurves, err = decodeCurves(data[h.OffsetM:], bCount)
+		if err != nil {
+			return
+		}
+	}
+	if h.OffsetA != 0 {
+		lut.ACurves, err = decodeCurves(data[h.OffsetA:], aCount)
+		if err != nil {
+			return
+		}
+	}
+	if h.OffsetCLUT != 0 {
+		c := data[h.OffsetCLUT:]
+		if len(c) < 20 {
+			err = fmt.Errorf("CLUT too short")
+			return
+		}
+		gridPoints := make([]int, clutIn)
+		for i := range gridPoints {
+			gridPoints[i] = int(c[i])
+		}
+		precision := int(c[16])
+		if precision != 1 && precision != 2 {
+			err = fmt.Errorf("invalid CLUT precision %d", precision)
+			return
+		}
+		lut.CLUT, err = decodeCLUTValues(c[20:], gridPoints, clutOut, precision)
+		if err != nil {
+			return
+		}
+	}
+	if (lut.MCurves == nil) != (lut.Matrix == nil) {
+		err = fmt.Errorf("lut must have both or neither of M curves and matrix")
+		return
+	}
+	if (lut.ACurves == nil) != (lut.CLUT == nil) {
+		err = fmt.Errorf("lut must have both or neither of A curves and CLUT")
+		return
+	}
+	// only a CLUT changes the number of channels, and a matrix works on 3 channels
+	if lut.CLUT == nil && lut.InputChannels != lut.OutputChannels {
+		err = fmt.Errorf("lut without CLUT must have the same number of input and output channels")
+		return
+	}
+	if lut.Matrix != nil && bCount != 3 {
+		err = fmt.Errorf("lut matrix requires 3 channels")
+		return
+	}
+	return
+}
+
+// decode a LUT-based tag type.
+// inputXYZ tells whether the input color space of the tag is XYZ.
+func decodeLut(data []byte, inputXYZ bool) (lut *Lut, err error) {
+	if len(data) < 8 {
+		err = fmt.Errorf("lut too short")
+		return
+	}
+	switch string(data[:4]) {
+	case tagTypeLut8, tagTypeLut16:
+		return decodeLutMFT(data, inputXYZ)
+	case tagTypeLutAB, tagTypeLutBA:
+		return decodeLutAB(data)
+	}
+	err = fmt.Errorf("not a lut type")
+	return
+}
+
+// TagLut decodes a LUT-based tag, such as AToB0 or BToA0.
+func (p *Profile) TagLut(signature string) (lut *Lut, err error) {
+	data, err := p.TagData(signature)
+	if err != nil {
+		return
+	}
+	if data == nil {
+		err = fmt.Errorf("tag %q not found", signature)
+		return
+	}
+	// AToB tags take the data color space; BToA, gamut and preview tags take the PCS
+	input := p.Header.PCS
+	if strings.HasPrefix(signature, "A2B") {
+		input = p.Header.ColorSpace
+	}
+	return decodeLut(data, input == ColorSpaceXYZ)
+}
+
+// apply curves to values in place
+func applyCurves(curves []Curve, v []float64) {
+	for i, c := range curves {
+		v[i] = c.Eval(v[i])
+	}
+}
+
+// apply a 3x3 matrix with optional offsets
+func applyMatrix(m []float64, v []float64) []float64 {
+	o := []float64{
+		m[0]*v[0] + m[1]*v[1] + m[2]*v[2],
+		m[3]*v[0] + m[4]*v[1] + m[5]*v[2],
+		m[6]*v[0] + m[7]*v[1] + m[8]*v[2],
+	}
+	if len(m) >= 12 {
+		o[0] += m[9]
+		o[1] += m[10]
+		o[2] += m[11]
+	}
+	for i := range o {
+		o[i] = clamp01(o[i])
+	}
+	return o
+}
+
+// Interpolation is a CLUT interpolation method.
+type Interpolation int
+
+const (
+	// InterpolationTrilinear interpolates the corners of a grid cell,
+	// i.e. trilinear interpolation for 3 input channels and its generalization for the others.
+	InterpolationTrilinear Interpolation = iota
+	// InterpolationTetrahedral interpolates the vertices of a tetrahedron in a grid cell.
+	// It is used for 3 and 4 input channels; the others fall back to InterpolationTrilinear.
+	InterpolationTetrahedral
+)
+
+func (ip Interpolation) String() string {
+	switch ip {
+	case InterpolationTrilinear:
+		return "trilinear"
+	case InterpolationTetrahedral:
+		return "tetrahedral"
+	}
+	return fmt.Sprintf("Interpolation(%d)", int(ip))
+}
+
+// locate the grid cell containing a point.
+// offset is the index of the base corner, stride is the index distance of grid points of each channel.
+func (c *CLUT) locate(in []float64) (offset int, stride []int, frac []float64) {
+	n := len(c.GridPoints)
+	stride = make([]int, n)
+	frac = make([]float64, n)
+	s := c.OutputChannels
+	for i := n - 1; i >= 0; i-- {
+		stride[i] = s
+		s *= c.GridPoints[i]
+		pos := clamp01(in[i]) * float64(c.GridPoints[i]-1)
+		b := int(pos)
+		if b >= c.GridPoints[i]-1 {
+			b = c.GridPoints[i] - 2
+		}
+		frac[i] = pos - float64(b)
+		offset += b * stride[i]
+	}
+	return
+}
+
+// multilinear interpolation
+func (c *CLUT) evalMultilinear(offset int, stride []int, frac []float64, out []float64) {
+	n := len(stride)
+	// sum up the weighted corners of the cell
+	for corner := 0; corner < 1<<n; corner++ {
+		w := 1.0
+		o := offset
+		for i := 0; i < n; i++ {
+			if corner&(1<<(n-1-i)) != 0 {
+				w *= frac[i]
+				o += stride[i]
+			} else {
+				w *= 1 - frac[i]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for j := range out {
+			out[j] += w * c.Values[o+j]
+		}
+	}
+}
+
+// tetrahedral interpolation of a 3-dimensional cell
+func (c *CLUT) evalTetrahedral(offset int, stride []int, frac []float64, out []float64) {
+	fx, fy, fz := frac[0], frac[1], frac[2]
+	sx, sy, sz := stride[0], stride[1], stride[2]
+
+	// the three vertices, in addition to the base and the opposite corners, of the tetrahedron
+	var v1, v2, v3 int
+	var f1, f2, f3 float64
+	switch {
+	case fx >= fy && fy >= fz:
+		v1, v2, v3, f1, f2, f3 = sx, sx+sy, sx+sy+sz, fx, fy, fz
+	case fx >= fz && fz >= fy:
+		v1, v2, v3, f1, f2, f3 = sx, sx+sz, sx+sy+sz, fx, fz, fy
+	case fz >= fx && fx >= fy:
+		v1, v2, v3, f1, f2, f3 = sz, sx+sz, sx+sy+sz, fz, fx, fy
+	case fy >= fx && fx >= fz:
+		v1, v2, v3, f1, f2, f3 = sy, sx+sy, sx+sy+sz, fy, fx, fz
+	case fy >= fz && fz >= fx:
+		v1, v2, v3, f1, f2, f3 = sy, sy+sz, sx+sy+sz, fy, fz, fx
+	default: // fz >= fy >= fx
+		v1, v2, v3, f1, f2, f3 = sz, sy+sz, sx+sy+sz, fz, fy, fx
+	}
+	t := c.Values[offset:]
+	for j := range out {
+		c0 := t[j]
+		out[j] += c0 + f1*(t[v1+j]-c0) + f2*(t[v2+j]-t[v1+j]) + f3*(t[v3+j]-t[v2+j])
+	}
+}
+
+// Eval evaluates the CLUT at a point using multilinear interpolation.
+// Input values are clamped to [0, 1].
+func (c *CLUT) Eval(in []float64) []float64 {
+	return c.EvalWith(in, InterpolationTrilinear)
+}
+
+// EvalWith evaluates the CLUT at a point using an interpolation method.
+// Input values are clamped to [0, 1].
+func (c *CLUT) EvalWith(in []float64, interpolation Interpolation) []float64 {
+	out := make([]float64, c.OutputChannels)
+	offset, stride, frac := c.locate(in)
+	if interpolation != InterpolationTetrahedral {
+		c.evalMultilinear(offset, stride, frac, out)
+		return out
+	}
+	switch len(c.GridPoints) {
+	case 3:
+		c.evalTetrahedral(offset, stride, frac, out)
+	case 4:
+		// linear interpolation of the first channel between two tetrahedral interpolations
+		lo := make([]float64, len(out))
+		c.evalTetrahedral(offset, stride[1:], frac[1:], lo)
+		hi := make([]float64, len(out))
+		c.evalTetrahedral(offset+stride[0], stride[1:], frac[1:], hi)
+		for j := range out {
+			out[j] = lo[j] + frac[0]*(hi[j]-lo[j])
+		}
+	default:
+		c.evalMultilinear(offset, stride, frac, out)
+	}
+	return out
+}
+
+// Eval evaluates the pipeline of the LUT using multilinear CLUT interpolation.
+// Values are normalized to [0, 1].
+func (l *Lut) Eval(in []float64) []float64 {
+	return l.EvalWith(in, InterpolationTrilinear)
+}
+
+// EvalWith evaluates the pipeline of the LUT using a CLUT interpolation method.
+// Values are normalized to [0, 1].
+func (l *Lut) EvalWith(in []float64, interpolation Interpolation) []float64 {
+	v := make([]float64, len(in))
+	copy(v, in)
+
+	clut := func() {
+		if l.CLUT != nil {
+			v = l.CLUT.EvalWith(v, interpolation)
+		}
+	}
+	matrix := func() {
+		if l.Matrix != nil {
+			v = applyMatrix(l.Matrix, v)
+		}
+	}
+
+	switch l.Type {
+	case tagTypeLutBA:
+		applyCurves(l.BCurves, v)
+		matrix()
+		applyCurves(l.MCurves, v)
+		clut()
+		applyCurves(l.ACurves, v)
+	case tagTypeLutAB:
+		applyCurves(l.ACurves, v)
+		clut()
+		applyCurves(l.MCurves, v)
+		matrix()
+		applyCurves(l.BCurves, v)
+	default: // mft1, mft2
+		matrix()
+		applyCurves(l.ACurves, v)
+		clut()
+		applyCurves(l.BCurves, v)
+	}
+	for i := range v {
+		v[i] = clamp01(v[i])
+	}
+	return v
+}
@@ -0,0 +1,238 @@
+package imageicc
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// an identity CLUT of 2 grid points with 16-bit precision
+func testIdentityCLUT(inputs int) []byte {
+	var b []byte
+	for i := 0; i < 1<<inputs; i++ {
+		for j := 0; j < inputs; j++ {
+			v := uint16(0)
+			if i&(1<<(inputs-1-j)) != 0 {
+				v = 0xffff
+			}
+			b = append(b, byte(v>>8), byte(v))
+		}
+	}
+	return b
+}
+
+func TestProfileLut(t *testing.T) {
+	be := binary.BigEndian
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-3 }
+
+	// lut16Type: 3 in, 3 out, identity matrix, linear input tables, identity CLUT and squaring output tables
+	mft2 := make([]byte, 52)
+	copy(mft2, "mft2")
+	mft2[8], mft2[9], mft2[10] = 3, 3, 2
+	for i := 0; i < 3; i++ {
+		be.PutUint32(mft2[12+i*16:], 0x10000)
+	}
+	be.PutUint16(mft2[48:], 2)
+	be.PutUint16(mft2[50:], 3)
+	for i := 0; i < 3; i++ {
+		mft2 = append(mft2, 0, 0, 0xff, 0xff)
+	}
+	mft2 = append(mft2, testIdentityCLUT(3)...)
+	for i := 0; i < 3; i++ {
+		mft2 = append(mft2, 0, 0, 0x40, 0x00, 0xff, 0xff)
+	}
+
+	// lutAToBType: 3 in, 3 out, with all elements
+	curv := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")
+	gamma2 := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x00\x00\x00")
+	mab := make([]byte, 32)
+	copy(mab, "mAB ")
+	mab[8], mab[9] = 3, 3
+	be.PutUint32(mab[12:], uint32(len(mab))) // B curves
+	for i := 0; i < 3; i++ {
+		mab = append(mab, curv...)
+	}
+	be.PutUint32(mab[16:], uint32(len(mab))) // matrix: swap the first two channels, with offset
+	mab = append(mab, testS15f16(0, 1, 0, 1, 0, 0, 0, 0, 0.5, 0, 0, 0.25)...)
+	be.PutUint32(mab[20:], uint32(len(mab))) // M curves
+	for i := 0; i < 3; i++ {
+		mab = append(mab, curv...)
+	}
+	be.PutUint32(mab[24:], uint32(len(mab))) // CLUT
+	clutHeader := make([]byte, 20)
+	clutHeader[0], clutHeader[1], clutHeader[2], clutHeader[16] = 2, 2, 2, 2
+	mab = append(mab, clutHeader...)
+	mab = append(mab, testIdentityCLUT(3)...)
+	be.PutUint32(mab[28:], uint32(len(mab))) // A curves
+	for i := 0; i < 3; i++ {
+		mab = append(mab, gamma2...)
+	}
+
+	// the same lut16Type with a matrix swapping the first two channels
+	mft2Swap := append([]byte{}, mft2...)
+	copy(mft2Swap[12:], testS15f16(0, 1, 0, 1, 0, 0, 0, 0, 1))
+
+	p, err := ParseProfile(testProfileWithTags([]testTag{
+		{"A2B0", mab},
+		{"A2B1", mft2Swap},
+		{"B2A0", mft2},
+		{"B2A1", mft2Swap},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lut, err := p.TagLut(TagBToA0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lut.Type != "mft2" || lut.InputChannels != 3 || lut.OutputChannels != 3 || lut.Matrix != nil {
+		t.Fatalf("lut16 header mismatch: %+v", lut)
+	}
+	out := lut.Eval([]float64{0.5, 0.25, 1})
+	if !near(out[0], 0.25) || !near(out[1], 0.125) || !near(out[2], 1) {
+		t.Errorf("lut16 output mismatch: %v", out)
+	}
+
+	// the matrix applies to the XYZ PCS input of BToA, but not to the RGB input of AToB
+	lut, err = p.TagLut(TagBToA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = lut.Eval([]float64{0.5, 0.25, 1})
+	if lut.Matrix == nil || !near(out[0], 0.125) || !near(out[1], 0.25) || !near(out[2], 1) {
+		t.Errorf("lut16 matrix output mismatch: %v", out)
+	}
+	lut, err = p.TagLut(TagAToB1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = lut.Eval([]float64{0.5, 0.25, 1})
+	if lut.Matrix != nil || !near(out[0], 0.25) || !near(out[1], 0.125) || !near(out[2], 1) {
+		t.Errorf("lut16 matrix applied to RGB input: %v", out)
+	}
+
+	lut, err = p.TagLut(TagAToB0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lut.Type != "mAB " || len(lut.Matrix) != 12 || lut.CLUT == nil || len(lut.ACurves) != 3 || len(lut.MCurves) != 3 {
+		t.Fatalf("lutAToB structure mismatch: %+v", lut)
+	}
+	out = lut.Eval([]float64{0.5, 0.25, 0.5})
+	if !near(out[0], 0.0625) || !near(out[1], 0.25) || !near(out[2], 0.375) {
+		t.Errorf("lutAToB output mismatch: %v", out)
+	}
+}
+
+func TestProfileLutMalformed(t *testing.T) {
+	be := binary.BigEndian
+	curv := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")
+
+	// a lutAToBType of given channels and elements; each element present if its count is nonzero
+	mab := func(in, out, b, m int, matrix bool) []byte {
+		d := make([]byte, 32)
+		copy(d, "mAB ")
+		d[8], d[9] = byte(in), byte(out)
+		be.PutUint32(d[12:], uint32(len(d)))
+		for i := 0; i < b; i++ {
+			d = append(d, curv...)
+		}
+		if matrix {
+			be.PutUint32(d[16:], uint32(len(d)))
+			d = append(d, testS15f16(0, 1, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0)...)
+		}
+		if m > 0 {
+			be.PutUint32(d[20:], uint32(len(d)))
+			for i := 0; i < m; i++ {
+				d = append(d, curv...)
+			}
+		}
+		return d
+	}
+	// a lut8Type with a matrix and an identity CLUT of 2 grid points
+	mft1 := func(in int, matrix []float64) []byte {
+		d := make([]byte, 48)
+		copy(d, "mft1")
+		d[8], d[9], d[10] = byte(in), 3, 2
+		copy(d[12:], testS15f16(matrix...))
+		for i := 0; i < in; i++ {
+			for j := 0; j < 256; j++ {
+				d = append(d, byte(j))
+			}
+		}
+		d = append(d, make([]byte, (1<<in)*3)...)
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 256; j++ {
+				d = append(d, byte(j))
+			}
+		}
+		return d
+	}
+	identity := []float64{1, 0, 0, 0, 1, 0, 0, 0, 1}
+	swap := []float64{0, 1, 0, 1, 0, 0, 0, 0, 1}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"B curves only", mab(3, 3, 3, 0, false), true},
+		{"B curves only, 1 to 3 channels", mab(1, 3, 3, 0, false), false},
+		{"B curves only, 3 to 1 channels", mab(3, 1, 1, 0, false), false},
+		{"matrix", mab(3, 3, 3, 3, true), true},
+		{"matrix of 1 channel", mab(1, 1, 1, 1, true), false},
+		{"matrix of 4 channels", mab(4, 4, 4, 4, true), false},
+		{"lut8 with matrix", mft1(3, swap), true},
+		{"lut8 with identity matrix of 1 input", mft1(1, identity), true},
+		{"lut8 with matrix of 1 input", mft1(1, swap), false},
+		{"lut8 with matrix of 4 inputs", mft1(4, swap), false},
+	} {
+		lut, err := decodeLut(tc.data, true)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%s: malformed lut accepted", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		in := make([]float64, lut.InputChannels)
+		if out := lut.Eval(in); len(out) != lut.OutputChannels {
+			t.Errorf("%s: %d output channels, want %d", tc.name, len(out), lut.OutputChannels)
+		}
+	}
+
+	// the matrix is ignored for input other than XYZ
+	if lut, err := decodeLut(mft1(4, swap), false); err != nil || lut.Matrix != nil {
+		t.Errorf("lut8 matrix of non-XYZ input not ignored: %v", err)
+	}
+
+	// truncations of a valid lut must not panic
+	valid := mab(3, 3, 3, 3, true)
+	for n := 0; n < len(valid); n++ {
+		if lut, err := decodeLut(valid[:n], true); err == nil {
+			lut.Eval(make([]float64, lut.InputChannels))
+		}
+	}
+}
+
+func TestCLUTInterpolation(t *testing.T) {
+	// f(x, y, z) = xyz on a 2x2x2 grid
+	c := &CLUT{GridPoints: []int{2, 2, 2}, OutputChannels: 1, Values: []float64{0, 0, 0, 0, 0, 0, 0, 1}}
+	in := []float64{0.5, 0.4, 0.3}
+	if v := c.EvalWith(in, InterpolationTrilinear); math.Abs(v[0]-0.06) > 1e-9 {
+		t.Errorf("trilinear mismatch: %v", v)
+	}
+	if v := c.EvalWith(in, InterpolationTetrahedral); math.Abs(v[0]-0.3) > 1e-9 {
+		t.Errorf("tetrahedral mismatch: %v", v)
+	}
+	// both are exact on grid points
+	for _, ip := range []Interpolation{InterpolationTrilinear, InterpolationTetrahedral} {
+		if v := c.EvalWith([]float64{1, 1, 1}, ip); v[0] != 1 {
+			t.Errorf("%v mismatch at a grid point: %v", ip, v)
+		}
+	}
+}
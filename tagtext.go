@@ -0,0 +1,345 @@
+//
+// decode and encode text tag types of ICC profiles
+//   textType 'text', textDescriptionType 'desc' (v2) and multiLocalizedUnicodeType 'mluc' (v4)
+//
+
+package imageicc
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+
+	bst "github.com/mixcode/binarystruct"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// text tag signatures
+const (
+	TagDescription     = "desc" // profile description
+	TagCopyright       = "cprt" // copyright
+	TagDeviceMfgDesc   = "dmnd" // device manufacturer description
+	TagDeviceModelDesc = "dmdd" // device model description
+	TagViewingCondDesc = "vued" // viewing condition description
+)
+
+// text tag type signatures
+const (
+	tagTypeText          = "text" // textType
+	tagTypeTextDesc      = "desc" // textDescriptionType
+	tagTypeMultiLocalize = "mluc" // multiLocalizedUnicodeType
+)
+
+// LocalizedText is a text in a language.
+// Language is an ISO 639-1 code and Country is an ISO 3166-1 code, both of which may be empty.
+type LocalizedText struct {
+	Language, Country string
+	Text              string
+}
+
+// remove trailing zeros of a C string
+func trimZero(b []byte) []byte {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return b
+}
+
+// decode UTF-16BE bytes to a string
+func decodeUTF16BE(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	for len(u) > 0 && u[len(u)-1] == 0 {
+		u = u[:len(u)-1]
+	}
+	return string(utf16.Decode(u))
+}
+
+// decode a textType
+func decodeText(data []byte) (s string, err error) {
+	if len(data) < 8 || string(data[:4]) != tagTypeText {
+		err = fmt.Errorf("not a textType")
+		return
+	}
+	return string(trimZero(data[8:])), nil
+}
+
+// textDescription is a decoded textDescriptionType
+type textDescription struct {
+	ascii      string // 7-bit ASCII description
+	unicode    string // Unicode description
+	scriptCode string // Macintosh ScriptCode description
+}
+
+// text encodings of Macintosh script codes
+var scriptCodeEncodings = map[uint16]encoding.Encoding{
+	0:  charmap.Macintosh,         // smRoman
+	1:  japanese.ShiftJIS,         // smJapanese
+	2:  traditionalchinese.Big5,   // smTradChinese
+	3:  korean.EUCKR,              // smKorean
+	7:  charmap.MacintoshCyrillic, // smCyrillic
+	25: simplifiedchinese.GBK,     // smSimpChinese
+}
+
+// decode a Macintosh ScriptCode string
+func decodeScriptCode(code uint16, b []byte) string {
+	if e, ok := scriptCodeEncodings[code]; ok {
+		if s, err := e.NewDecoder().Bytes(b); err == nil {
+			return string(s)
+		}
+	}
+	return string(toASCII(string(b)))
+}
+
+// decode a textDescriptionType, with its ASCII, Unicode and ScriptCode descriptions
+func decodeTextDescription(data []byte) (desc textDescription, err error) {
+	if len(data) < 12 || string(data[:4]) != tagTypeTextDesc {
+		err = fmt.Errorf("not a textDescriptionType")
+		return
+	}
+	n := int(bst.BigEndian.Uint32(data[8:]))
+	data = data[12:]
+	if n > len(data) {
+		err = fmt.Errorf("textDescriptionType too short")
+		return
+	}
+	desc.ascii = string(trimZero(data[:n]))
+	data = data[n:]
+
+	// the Unicode and ScriptCode parts; many profiles in the wild omit or truncate them
+	if len(data) < 8 {
+		return
+	}
+	var uniHeader struct {
+		LanguageCode uint32
+		Count        int `binary:"uint32"` // number of UTF-16 characters
+	}
+	_, err = bst.Unmarshal(data, bst.BigEndian, &uniHeader)
+	if err != nil {
+		return
+	}
+	data = data[8:]
+	if uniHeader.Count*2 > len(data) {
+		err = fmt.Errorf("textDescriptionType too short")
+		return
+	}
+	desc.unicode = decodeUTF16BE(data[:uniHeader.Count*2])
+	data = data[uniHeader.Count*2:]
+
+	// ScriptCode code, count including the terminating zero, and a 67-byte field
+	if len(data) < 3 {
+		return
+	}
+	code, count := bst.BigEndian.Uint16(data), int(data[2])
+	data = data[3:]
+	if count > 67 {
+		count = 67
+	}
+	if count > len(data) {
+		return
+	}
+	if b := trimZero(data[:count]); len(b) > 0 {
+		desc.scriptCode = decodeScriptCode(code, b)
+	}
+	return
+}
+
+// the preferred text of a textDescriptionType;
+// Unicode first as ASCII descriptions of localized profiles are often mangled
+func (d textDescription) text() string {
+	switch {
+	case d.unicode != "":
+		return d.unicode
+	case d.ascii != "":
+		return d.ascii
+	}
+	return d.scriptCode
+}
+
+// encode a string to UTF-16BE bytes
+func encodeUTF16BE(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, len(u)*2)
+	for i, c := range u {
+		b[2*i], b[2*i+1] = byte(c>>8), byte(c)
+	}
+	return b
+}
+
+// convert a string to 7-bit ASCII, replacing other characters with '?'
+func toASCII(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r >= 0x80 || r == 0 {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// encode a textType
+func encodeText(s string) []byte {
+	b := make([]byte, 8, 8+len(s)+1)
+	copy(b, tagTypeText)
+	b = append(b, toASCII(s)...)
+	return append(b, 0)
+}
+
+// encode a textDescriptionType, with both ASCII and Unicode descriptions
+func encodeTextDescription(s string) []byte {
+	ascii := append(toASCII(s), 0)
+	unicode := append(encodeUTF16BE(s), 0, 0)
+	be := bst.BigEndian
+	b := make([]byte, 12+len(ascii)+8+len(unicode)+3+67)
+	copy(b, tagTypeTextDesc)
+	be.PutUint32(b[8:], uint32(len(ascii)))
+	copy(b[12:], ascii)
+	u := b[12+len(ascii):]
+	// Unicode language code 0
+	be.PutUint32(u[4:], uint32(len(unicode)/2))
+	copy(u[8:], unicode)
+	// empty ScriptCode part follows
+	return b
+}
+
+// encode a multiLocalizedUnicodeType
+func encodeMLUC(texts []LocalizedText) []byte {
+	be := bst.BigEndian
+	b := make([]byte, 16+12*len(texts))
+	copy(b, tagTypeMultiLocalize)
+	be.PutUint32(b[8:], uint32(len(texts)))
+	be.PutUint32(b[12:], 12)
+	for i, t := range texts {
+		rec := b[16+i*12:]
+		copy(rec[0:2], t.Language)
+		copy(rec[2:4], t.Country)
+		s := encodeUTF16BE(t.Text)
+		be.PutUint32(rec[4:], uint32(len(s)))
+		be.PutUint32(rec[8:], uint32(len(b)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+// decode a multiLocalizedUnicodeType
+func decodeMLUC(data []byte) (texts []LocalizedText, err error) {
+	if len(data) < 16 || string(data[:4]) != tagTypeMultiLocalize {
+		err = fmt.Errorf("not a multiLocalizedUnicodeType")
+		return
+	}
+	var mluc struct {
+		Count      int `binary:"uint32"` // number of records
+		RecordSize int `binary:"uint32"` // size of a record, 12
+	}
+	_, err = bst.Unmarshal(data[8:], bst.BigEndian, &mluc)
+	if err != nil {
+		return
+	}
+	if mluc.RecordSize < 12 || mluc.Count > (len(data)-16)/mluc.RecordSize {
+		err = fmt.Errorf("invalid multiLocalizedUnicodeType record table")
+		return
+	}
+	texts = make([]LocalizedText, 0, mluc.Count)
+	for i := 0; i < mluc.Count; i++ {
+		var rec struct {
+			Language string `binary:"[2]byte"`
+			Country  string `binary:"[2]byte"`
+			Length   int    `binary:"uint32"`
+			Offset   int    `binary:"uint32"` // offset from the start of the tag
+		}
+		_, err = bst.Unmarshal(data[16+i*mluc.RecordSize:], bst.BigEndian, &rec)
+		if err != nil {
+			return
+		}
+		if rec.Offset+rec.Length > len(data) || rec.Offset+rec.Length < rec.Offset {
+			err = fmt.Errorf("multiLocalizedUnicodeType record out of range")
+			return
+		}
+		texts = append(texts, LocalizedText{
+			Language: string(trimZero([]byte(rec.Language))),
+			Country:  string(trimZero([]byte(rec.Country))),
+			Text:     decodeUTF16BE(data[rec.Offset : rec.Offset+rec.Length]),
+		})
+	}
+	return
+}
+
+// TagLocalizedText decodes a text tag into texts of all available languages.
+// Tags of textType and textDescriptionType have a single text without language.
+// If there is no such tag then nil data and no error is returned.
+func (p *Profile) TagLocalizedText(signature string) (texts []LocalizedText, err error) {
+	data, err := p.TagData(signature)
+	if err != nil || data == nil {
+		return
+	}
+	switch string(data[:4]) {
+	case tagTypeMultiLocalize:
+		return decodeMLUC(data)
+	case tagTypeTextDesc:
+		var desc textDescription
+		desc, err = decodeTextDescription(data)
+		if err != nil {
+			return
+		}
+		return []LocalizedText{{Text: desc.text()}}, nil
+	case tagTypeText:
+		var s string
+		s, err = decodeText(data)
+		if err != nil {
+			return
+		}
+		return []LocalizedText{{Text: s}}, nil
+	}
+	err = fmt.Errorf("tag %q is not a text type", signature)
+	return
+}
+
+// TagText decodes a text tag. English text is preferred if there are multiple languages.
+// If there is no such tag then an empty string and no error is returned.
+func (p *Profile) TagText(signature string) (s string, err error) {
+	texts, err := p.TagLocalizedText(signature)
+	if err != nil || len(texts) == 0 {
+		return
+	}
+	best := 0
+	for i, t := range texts {
+		if t.Language == "en" {
+			if t.Country == "US" {
+				best = i
+				break
+			}
+			if texts[best].Language != "en" {
+				best = i
+			}
+		}
+	}
+	return texts[best].Text, nil
+}
+
+// Description returns the profile description, e.g. "sRGB IEC61966-2.1".
+func (p *Profile) Description() (string, error) {
+	return p.TagText(TagDescription)
+}
+
+// Copyright returns the profile copyright text.
+func (p *Profile) Copyright() (string, error) {
+	return p.TagText(TagCopyright)
+}
+
+// DeviceManufacturer returns the device manufacturer description.
+func (p *Profile) DeviceManufacturer() (string, error) {
+	return p.TagText(TagDeviceMfgDesc)
+}
+
+// DeviceModel returns the device model description.
+func (p *Profile) DeviceModel() (string, error) {
+	return p.TagText(TagDeviceModelDesc)
+}
@@ -0,0 +1,111 @@
+package imageicc
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// encode a string to UTF-16BE
+func testUTF16BE(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		b = append(b, byte(r>>8), byte(r))
+	}
+	return b
+}
+
+func TestProfileText(t *testing.T) {
+	be := binary.BigEndian
+
+	// v2 textDescriptionType: ASCII, Unicode and ScriptCode parts
+	desc := []byte("desc\x00\x00\x00\x00\x00\x00\x00\x0aDisplay P3\x00\x00\x00\x00\x00\x00\x00\x00")
+	desc = append(desc, make([]byte, 2+1+67)...)
+
+	// v4 multiLocalizedUnicodeType with two languages
+	mluc := make([]byte, 16+12*2)
+	copy(mluc, "mluc")
+	be.PutUint32(mluc[8:], 2)
+	be.PutUint32(mluc[12:], 12)
+	for i, rec := range []struct{ lang, text string }{{"jaJP", "テスト"}, {"enUS", "Test Inc."}} {
+		u := testUTF16BE(rec.text)
+		r := mluc[16+12*i:]
+		copy(r, rec.lang)
+		be.PutUint32(r[4:], uint32(len(u)))
+		be.PutUint32(r[8:], uint32(len(mluc)))
+		mluc = append(mluc, u...)
+	}
+
+	cprt := []byte("text\x00\x00\x00\x00No copyright\x00")
+
+	p, err := ParseProfile(testProfileWithTags([]testTag{
+		{"desc", desc},
+		{"dmnd", mluc},
+		{"cprt", cprt},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := p.Description(); err != nil || s != "Display P3" {
+		t.Errorf("description mismatch: %q, %v", s, err)
+	}
+	if s, err := p.DeviceManufacturer(); err != nil || s != "Test Inc." {
+		t.Errorf("manufacturer mismatch: %q, %v", s, err)
+	}
+	if s, err := p.Copyright(); err != nil || s != "No copyright" {
+		t.Errorf("copyright mismatch: %q, %v", s, err)
+	}
+	if s, err := p.DeviceModel(); err != nil || s != "" {
+		t.Errorf("model mismatch: %q, %v", s, err)
+	}
+	texts, err := p.TagLocalizedText("dmnd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || texts[0].Language != "ja" || texts[0].Country != "JP" || texts[0].Text != "テスト" {
+		t.Errorf("localized text mismatch: %v", texts)
+	}
+
+	// textDescriptionType parts
+	textDesc := func(ascii, unicode string, scriptCode uint16, script []byte) []byte {
+		b := []byte("desc\x00\x00\x00\x00")
+		b = append(b, 0, 0, 0, byte(len(ascii)+1))
+		b = append(b, ascii...)
+		b = append(b, 0, 0, 0, 0, 0) // terminator and Unicode language code
+		u := testUTF16BE(unicode)
+		if len(u) > 0 {
+			u = append(u, 0, 0)
+		}
+		b = append(b, 0, 0, 0, byte(len(u)/2))
+		b = append(b, u...)
+		b = append(b, byte(scriptCode>>8), byte(scriptCode))
+		if len(script) > 0 {
+			b = append(b, byte(len(script)+1))
+		} else {
+			b = append(b, 0)
+		}
+		sc := make([]byte, 67)
+		copy(sc, script)
+		return append(b, sc...)
+	}
+	for _, tc := range []struct {
+		data                       []byte
+		ascii, unicode, scriptCode string
+		text                       string
+	}{
+		{textDesc("Caf? Profil", "Café Profil", 0, []byte("Caf\x8e Profil")), "Caf? Profil", "Café Profil", "Café Profil", "Café Profil"},
+		{textDesc("Test", "", 0, nil), "Test", "", "", "Test"},
+		{textDesc("", "", 1, []byte("\x83e\x83X\x83g")), "", "", "テスト", "テスト"},
+		{desc, "Display P3", "", "", "Display P3"},
+	} {
+		d, err := decodeTextDescription(tc.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.ascii != tc.ascii || d.unicode != tc.unicode || d.scriptCode != tc.scriptCode {
+			t.Errorf("textDescriptionType mismatch: %+v", d)
+		}
+		if s := d.text(); s != tc.text {
+			t.Errorf("textDescriptionType text %q, want %q", s, tc.text)
+		}
+	}
+}
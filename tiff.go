@@ -1,5 +1,5 @@
 //
-// read embedded ICC profile from a TIFF file
+// read and write embedded ICC profile in a TIFF file
 //
 // TIFF spec
 // https://www.adobe.io/open/standards/TIFF.html
@@ -10,6 +10,7 @@ package imageicc
 import (
 	"fmt"
 	"io"
+	"sort"
 
 	bst "github.com/mixcode/binarystruct"
 )
@@ -44,6 +45,9 @@ const (
 	tifTypeDOUBLE    = 12 // float64
 )
 
+// tag id of the ICC profile, TIFFTAG_ICCPROFILE
+const tifTagICCProfile = 0x8773
+
 var (
 	// byte size of TIF value type
 	tifTypeSize = []int{
@@ -56,7 +60,7 @@ var (
 
 func (d *tifDirEntry) fetchRawData(in io.ReadSeeker, endian bst.ByteOrder) (b []byte, err error) {
 	sz := d.Count * tifTypeSize[d.Type]
-	if sz < 4 { // data fit in the d.Value field
+	if sz <= 4 { // data fit in the d.Value field
 		// revert value to []byte
 		b, err = bst.Marshal(d.Value, endian)
 		if err == nil && b != nil {
@@ -199,17 +203,16 @@ func (d *tifDirEntry) getIntArray(in io.ReadSeeker, endian bst.ByteOrder) (n []i
 	return
 }
 
-// Parse TIFF tags and find an embedded ICC profile
-func LoadICCfromTIFF(in io.ReadSeeker) (iccProfile []byte, err error) {
+// read TIFF header and get the byte order and the offset to the first IFD
+func readTIFFHeader(in io.ReadSeeker) (endian bst.ByteOrder, offsetIFD int64, err error) {
 
-	buf := make([]byte, 16)
+	buf := make([]byte, 8)
 
 	// read TIFF header
 	_, err = io.ReadFull(in, buf[:8])
 	if err != nil {
 		return
 	}
-	var endian bst.ByteOrder
 	// First two bytes indicate the byte order
 	if buf[0] == 'I' && buf[1] == 'I' {
 		// "II\0x2a\0" : Little-endian TIFF
@@ -234,26 +237,67 @@ func LoadICCfromTIFF(in io.ReadSeeker) (iccProfile []byte, err error) {
 		err = fmt.Errorf("invalid TIF header")
 		return
 	}
+	return endian, tifHeader.OffsetIfd, nil
+}
+
+// an IFD and its location in the file
+type tifIFDLoc struct {
+	tifIFD
+	Offset     int64 // file offset of the IFD
+	PointerPos int64 // file offset of the field pointing to this IFD
+}
+
+// read the chain of image file directories
+func readTIFFIFDs(in io.ReadSeeker, endian bst.ByteOrder, offsetIFD int64) (ifds []tifIFDLoc, err error) {
+	visited := make(map[int64]bool)
+	pointerPos := int64(4) // the header holds the offset to the first IFD
+	for offsetIFD != 0 {
+		if visited[offsetIFD] {
+			err = fmt.Errorf("circular IFD chain")
+			return
+		}
+		visited[offsetIFD] = true
 
-	// read image file directories
-	ifd := tifIFD{DirEntry: make([]tifDirEntry, 0)}
-	ifdOffset := tifHeader.OffsetIfd
-	for ifdOffset != 0 {
 		// seek to the ifd offset
-		_, err = in.Seek(ifdOffset, io.SeekStart)
+		_, err = in.Seek(offsetIFD, io.SeekStart)
 		if err != nil {
 			return
 		}
 		// read single ifd block
-		var lfd tifIFD
-		_, err = bst.Read(in, endian, &lfd)
+		var loc tifIFDLoc
+		_, err = bst.Read(in, endian, &loc.tifIFD)
 		if err != nil {
 			return
 		}
+		loc.Offset, loc.PointerPos = offsetIFD, pointerPos
+		ifds = append(ifds, loc)
+
+		pointerPos = offsetIFD + 2 + 12*int64(loc.NumEntry)
+		offsetIFD = loc.OffsetNextIFD
+	}
+	return
+}
+
+// Parse TIFF tags and find an embedded ICC profile
+func LoadICCfromTIFF(in io.ReadSeeker) (iccProfile []byte, err error) {
+
+	var buf []byte
+
+	endian, offsetIFD, err := readTIFFHeader(in)
+	if err != nil {
+		return
+	}
+
+	// read image file directories
+	ifds, err := readTIFFIFDs(in, endian, offsetIFD)
+	if err != nil {
+		return
+	}
+	ifd := tifIFD{DirEntry: make([]tifDirEntry, 0)}
+	for _, lfd := range ifds {
 		// append new ifd to the main ifd table
 		ifd.NumEntry += lfd.NumEntry
 		ifd.DirEntry = append(ifd.DirEntry, lfd.DirEntry...)
-		ifdOffset = ifd.OffsetNextIFD
 	}
 
 	// Seek for a ICC profile tag
@@ -271,7 +315,7 @@ func LoadICCfromTIFF(in io.ReadSeeker) (iccProfile []byte, err error) {
 
 		switch d.Tag {
 
-		case 0x8773: // 0x8773: TIFFTAG_ICCPROFILE
+		case tifTagICCProfile: // 0x8773: TIFFTAG_ICCPROFILE
 			// ICC profile found; load the data block
 			buf, err = d.getBytes(in, endian)
 			if err != nil {
@@ -289,3 +333,269 @@ func LoadICCfromTIFF(in io.ReadSeeker) (iccProfile []byte, err error) {
 
 	return
 }
+
+// a byte range of a file
+type tifRange struct {
+	Start, End int64
+}
+
+// a block of data written at a file offset
+type tifWrite struct {
+	Pos  int64
+	Data []byte
+}
+
+// copy a TIFF stream, replacing the ICC profile tag of IFDs.
+// If ifdIndex is negative then all IFDs are processed.
+// The tag is removed if iccProfile is nil.
+//
+// The old profile data and the old IFDs are cleared, and the space is reused for
+// the new profile data and the modified IFDs; what does not fit is appended to the end of the file.
+// Cleared space at the end of the file is cut off.
+func rewriteTIFFICC(out io.Writer, in io.ReadSeeker, iccProfile []byte, ifdIndex int) (err error) {
+
+	endian, offsetIFD, err := readTIFFHeader(in)
+	if err != nil {
+		return
+	}
+	ifds, err := readTIFFIFDs(in, endian, offsetIFD)
+	if err != nil {
+		return
+	}
+	if ifdIndex >= len(ifds) {
+		err = fmt.Errorf("IFD %d not found", ifdIndex)
+		return
+	}
+	fileSize, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+
+	// build modified IFDs
+	iccEntry := tifDirEntry{Tag: tifTagICCProfile, Type: tifTypeUNDEFINED, Count: len(iccProfile)}
+	modified := make([]*tifIFD, len(ifds))
+	for i, loc := range ifds {
+		if ifdIndex >= 0 && i != ifdIndex {
+			continue
+		}
+		entries := make([]tifDirEntry, 0, len(loc.DirEntry)+1)
+		found := false
+		for _, d := range loc.DirEntry {
+			if d.Tag == tifTagICCProfile {
+				found = true
+				continue
+			}
+			entries = append(entries, d)
+		}
+		if iccProfile != nil {
+			entries = append(entries, iccEntry)
+			// entries must be sorted by tag id
+			sort.SliceStable(entries, func(a, b int) bool { return entries[a].Tag < entries[b].Tag })
+		} else if !found {
+			// nothing to remove
+			continue
+		}
+		modified[i] = &tifIFD{NumEntry: len(entries), DirEntry: entries, OffsetNextIFD: loc.OffsetNextIFD}
+	}
+
+	// space freed by the old IFDs and the old profile data, unless still used by other IFDs
+	inUse := make(map[int64]bool)
+	for i, loc := range ifds {
+		if modified[i] != nil {
+			continue
+		}
+		for _, d := range loc.DirEntry {
+			if d.Tag == tifTagICCProfile {
+				inUse[int64(d.Value)] = true
+			}
+		}
+	}
+	var free []tifRange
+	for i, loc := range ifds {
+		if modified[i] == nil {
+			continue
+		}
+		free = append(free, tifRange{loc.Offset, loc.Offset + 2 + 12*int64(loc.NumEntry) + 4})
+		for _, d := range loc.DirEntry {
+			if d.Tag != tifTagICCProfile || int(d.Type) >= len(tifTypeSize) {
+				continue
+			}
+			sz := int64(d.Count) * int64(tifTypeSize[d.Type])
+			if sz > 4 && !inUse[int64(d.Value)] && int64(d.Value)+sz <= fileSize {
+				free = append(free, tifRange{int64(d.Value), int64(d.Value) + sz})
+			}
+		}
+	}
+
+	// merge adjacent ranges, including word-alignment padding between them
+	sort.Slice(free, func(a, b int) bool { return free[a].Start < free[b].Start })
+	merged := free[:0]
+	for _, r := range free {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End+merged[n-1].End%2 {
+			if r.End > merged[n-1].End {
+				merged[n-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	free = merged
+
+	// cut off the freed space at the end of the file
+	fileEnd := fileSize
+	if n := len(free); n > 0 && free[n-1].End+free[n-1].End%2 >= fileSize {
+		fileEnd = free[n-1].Start
+		free = free[:n-1]
+	}
+
+	// allocate word-aligned space in the freed ranges, or at the end of the file
+	tail := fileEnd + fileEnd%2
+	alloc := func(size int64) int64 {
+		for i, r := range free {
+			pos := r.Start + r.Start%2
+			if pos+size > r.End {
+				continue
+			}
+			// the rest of the range remains free
+			rest := []tifRange{}
+			if pos > r.Start {
+				rest = append(rest, tifRange{r.Start, pos})
+			}
+			if pos+size < r.End {
+				rest = append(rest, tifRange{pos + size, r.End})
+			}
+			free = append(free[:i], append(rest, free[i+1:]...)...)
+			return pos
+		}
+		pos := tail
+		tail += size + size%2
+		return pos
+	}
+
+	var writes []tifWrite
+
+	// the profile data
+	if iccProfile != nil {
+		if len(iccProfile) <= 4 {
+			// data fit in the Value field
+			v := make([]byte, 4)
+			copy(v, iccProfile)
+			iccEntry.Value = endian.Uint32(v)
+		} else {
+			iccEntry.Value = uint32(alloc(int64(len(iccProfile))))
+			writes = append(writes, tifWrite{int64(iccEntry.Value), iccProfile})
+		}
+		for _, ifd := range modified {
+			if ifd == nil {
+				continue
+			}
+			for j := range ifd.DirEntry {
+				if ifd.DirEntry[j].Tag == tifTagICCProfile {
+					ifd.DirEntry[j] = iccEntry
+				}
+			}
+		}
+	}
+
+	// place the modified IFDs
+	newOffset := make([]int64, len(ifds))
+	for i, loc := range ifds {
+		newOffset[i] = loc.Offset
+		if modified[i] != nil {
+			newOffset[i] = alloc(2 + 12*int64(modified[i].NumEntry) + 4)
+		}
+	}
+	if tail > 0xffffffff {
+		err = fmt.Errorf("file too large")
+		return
+	}
+
+	// fix pointers to the relocated IFDs
+	for i, loc := range ifds {
+		if modified[i] == nil || newOffset[i] == loc.Offset {
+			continue
+		}
+		if i > 0 && modified[i-1] != nil {
+			// the previous IFD is also rewritten
+			modified[i-1].OffsetNextIFD = newOffset[i]
+		} else {
+			var b [4]byte
+			endian.PutUint32(b[:], uint32(newOffset[i]))
+			writes = append(writes, tifWrite{loc.PointerPos, b[:]})
+		}
+	}
+	for i, ifd := range modified {
+		if ifd == nil {
+			continue
+		}
+		var b []byte
+		b, err = bst.Marshal(ifd, endian)
+		if err != nil {
+			return
+		}
+		writes = append(writes, tifWrite{newOffset[i], b})
+	}
+	// clear the freed space left
+	for _, r := range free {
+		writes = append(writes, tifWrite{r.Start, make([]byte, r.End-r.Start)})
+	}
+
+	// copy the original file with the writes
+	sort.Slice(writes, func(a, b int) bool { return writes[a].Pos < writes[b].Pos })
+	var pos int64 // output position
+	copyTo := func(end int64) error {
+		if end > fileEnd {
+			end = fileEnd
+		}
+		if pos < end {
+			if _, err := in.Seek(pos, io.SeekStart); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(out, in, end-pos); err != nil {
+				return err
+			}
+			pos = end
+		}
+		return nil
+	}
+	for _, w := range writes {
+		err = copyTo(w.Pos)
+		if err != nil {
+			return
+		}
+		if pos < w.Pos {
+			// padding
+			_, err = out.Write(make([]byte, w.Pos-pos))
+			if err != nil {
+				return
+			}
+		}
+		_, err = out.Write(w.Data)
+		if err != nil {
+			return
+		}
+		pos = w.Pos + int64(len(w.Data))
+	}
+	return copyTo(fileEnd)
+}
+
+// Copy a TIFF file from in to out, embedding an ICC profile in the ifdIndex-th IFD.
+// The first IFD, index 0, is the main image in most files.
+// Any ICC profile already in the IFD is replaced.
+func EmbedICCtoTIFF(out io.Writer, in io.ReadSeeker, iccProfile []byte, ifdIndex int) (err error) {
+	if len(iccProfile) == 0 {
+		err = fmt.Errorf("empty icc profile")
+		return
+	}
+	if ifdIndex < 0 {
+		err = fmt.Errorf("invalid IFD index")
+		return
+	}
+	return rewriteTIFFICC(out, in, iccProfile, ifdIndex)
+}
+
+// Copy a TIFF file from in to out, removing ICC profiles from all IFDs.
+// The profile data is cleared, and cut off if it is at the end of the file.
+func StripICCfromTIFF(out io.Writer, in io.ReadSeeker) (err error) {
+	return rewriteTIFFICC(out, in, nil, -1)
+}
@@ -0,0 +1,256 @@
+package imageicc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// a minimal TIFF file with two IFDs, without actual image data
+func testTIFF(endian bst.ByteOrder) []byte {
+	var buf bytes.Buffer
+	if endian == bst.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	bst.Write(&buf, endian, struct {
+		Magic     uint16
+		OffsetIfd uint32
+	}{42, 8})
+	bst.Write(&buf, endian, &tifIFD{
+		NumEntry: 2,
+		DirEntry: []tifDirEntry{
+			{Tag: 0x100, Type: tifTypeLONG, Count: 1, Value: 16}, // ImageWidth
+			{Tag: 0x101, Type: tifTypeLONG, Count: 1, Value: 8},  // ImageLength
+		},
+		OffsetNextIFD: 8 + 30,
+	})
+	bst.Write(&buf, endian, &tifIFD{
+		NumEntry: 1,
+		DirEntry: []tifDirEntry{
+			{Tag: 0x100, Type: tifTypeLONG, Count: 1, Value: 4},
+		},
+	})
+	return buf.Bytes()
+}
+
+func TestEmbedICCtoTIFF(t *testing.T) {
+	for _, endian := range []bst.ByteOrder{bst.LittleEndian, bst.BigEndian} {
+		src := testTIFF(endian)
+
+		// embed to the second IFD
+		icc := testProfile(999, 1)
+		var dst bytes.Buffer
+		err := EmbedICCtoTIFF(&dst, bytes.NewReader(src), icc, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadICCfromTIFF(bytes.NewReader(dst.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, icc) {
+			t.Fatalf("embedded profile mismatch")
+		}
+
+		// embed to the first IFD too
+		icc2 := testProfile(500, 2)
+		var dst2 bytes.Buffer
+		err = EmbedICCtoTIFF(&dst2, bytes.NewReader(dst.Bytes()), icc2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(dst2.Bytes())
+		_, offset, err := readTIFFHeader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ifds, err := readTIFFIFDs(r, endian, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ifds) != 2 || ifds[0].NumEntry != 3 || ifds[1].NumEntry != 2 {
+			t.Fatalf("IFD chain mismatch")
+		}
+		for i, want := range [][]byte{icc2, icc} {
+			d := ifds[i].DirEntry[len(ifds[i].DirEntry)-1]
+			b, err := d.getBytes(r, endian)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Tag != tifTagICCProfile || !bytes.Equal(b, want) {
+				t.Fatalf("profile mismatch in IFD %d", i)
+			}
+		}
+
+		// strip both profiles; the data must be gone
+		var stripped bytes.Buffer
+		err = StripICCfromTIFF(&stripped, bytes.NewReader(dst2.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := stripped.Bytes()
+		if len(sb) >= len(src)+len(icc2) || bytes.Contains(sb, icc[4:]) || bytes.Contains(sb, icc2[4:]) {
+			t.Fatalf("profile data left: %d bytes", len(sb))
+		}
+		r = bytes.NewReader(sb)
+		_, offset, err = readTIFFHeader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ifds, err = readTIFFIFDs(r, endian, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ifds) != 2 || ifds[0].NumEntry != 2 || ifds[1].NumEntry != 1 || ifds[1].DirEntry[0].Value != 4 {
+			t.Fatalf("IFD chain of the stripped file mismatch")
+		}
+	}
+}
+
+func TestLibtiffSample(t *testing.T) {
+	// written by libtiff with the ICC profile tag, in both byte orders
+	want := testSample(t, "display-p3-v2.icc")
+	icc := testSample(t, "large-v2.icc")
+	for _, name := range []string{"libtiff-display-p3-le.tif", "libtiff-display-p3-be.tif"} {
+		src := testSample(t, name)
+		loaded, err := LoadICCfromTIFF(bytes.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, want) {
+			t.Fatalf("%s: profile of %d bytes differs from the source profile", name, len(loaded))
+		}
+		entries := testTIFFEntryData(t, src)
+
+		// replace and strip; the other entries must stay the same
+		var dst bytes.Buffer
+		err = EmbedICCtoTIFF(&dst, bytes.NewReader(src), icc, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded, err = LoadICCfromTIFF(bytes.NewReader(dst.Bytes())); err != nil || !bytes.Equal(loaded, icc) {
+			t.Fatalf("%s: replaced profile mismatch: %v", name, err)
+		}
+		var stripped bytes.Buffer
+		err = StripICCfromTIFF(&stripped, bytes.NewReader(dst.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded, err = LoadICCfromTIFF(bytes.NewReader(stripped.Bytes())); err != nil || loaded != nil {
+			t.Fatalf("%s: profile not removed: %v", name, err)
+		}
+		for _, b := range [][]byte{dst.Bytes(), stripped.Bytes()} {
+			if !reflect.DeepEqual(testTIFFEntryData(t, b), entries) {
+				t.Errorf("%s: entry data changed", name)
+			}
+		}
+	}
+}
+
+// a TIFF file with an ICC profile and other tags whose data are outside the IFD
+func testTIFFWithData(endian bst.ByteOrder, icc []byte) []byte {
+	desc := []byte("a test image description\x00")
+	const ifdSize = 2 + 12*7 + 4
+	iccPos := int64(8 + ifdSize)
+	descPos := iccPos + int64(len(icc))
+	resPos := descPos + int64(len(desc))
+	resPos += resPos % 2
+	stripPos := resPos + 8
+
+	var buf bytes.Buffer
+	if endian == bst.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	bst.Write(&buf, endian, struct {
+		Magic     uint16
+		OffsetIfd uint32
+	}{42, 8})
+	bst.Write(&buf, endian, &tifIFD{
+		NumEntry: 7,
+		DirEntry: []tifDirEntry{
+			{Tag: 0x100, Type: tifTypeLONG, Count: 1, Value: 4},                        // ImageWidth
+			{Tag: 0x101, Type: tifTypeLONG, Count: 1, Value: 2},                        // ImageLength
+			{Tag: 0x10e, Type: tifTypeASCII, Count: len(desc), Value: uint32(descPos)}, // ImageDescription
+			{Tag: 0x111, Type: tifTypeLONG, Count: 1, Value: uint32(stripPos)},         // StripOffsets
+			{Tag: 0x117, Type: tifTypeLONG, Count: 1, Value: 8},                        // StripByteCounts
+			{Tag: 0x11a, Type: tifTypeRATIONAL, Count: 1, Value: uint32(resPos)},       // XResolution
+			{Tag: tifTagICCProfile, Type: tifTypeUNDEFINED, Count: len(icc), Value: uint32(iccPos)},
+		},
+	})
+	buf.Write(icc)
+	buf.Write(desc)
+	for int64(buf.Len()) < resPos {
+		buf.WriteByte(0)
+	}
+	bst.Write(&buf, endian, []uint32{72, 1})
+	buf.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	return buf.Bytes()
+}
+
+// the type and data of IFD entries other than the ICC profile, and the strip data under tag 0
+func testTIFFEntryData(t *testing.T, file []byte) map[uint16][]byte {
+	r := bytes.NewReader(file)
+	endian, offset, err := readTIFFHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifds, err := readTIFFIFDs(r, endian, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make(map[uint16][]byte)
+	var stripOffset, stripSize int64
+	for _, d := range ifds[0].DirEntry {
+		if d.Tag == tifTagICCProfile {
+			continue
+		}
+		b, err := d.fetchRawData(r, endian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[d.Tag] = append([]byte{byte(d.Type)}, b...)
+		switch d.Tag {
+		case 0x111: // StripOffsets
+			stripOffset, err = d.getInt()
+		case 0x117: // StripByteCounts
+			stripSize, err = d.getInt()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data[0] = file[stripOffset : stripOffset+stripSize]
+	return data
+}
+
+func TestRewriteTIFFKeepsOtherData(t *testing.T) {
+	for _, endian := range []bst.ByteOrder{bst.LittleEndian, bst.BigEndian} {
+		src := testTIFFWithData(endian, testProfile(300, 1))
+		want := testTIFFEntryData(t, src)
+		if len(want) != 7 {
+			t.Fatalf("test file entries: %d", len(want))
+		}
+		for _, size := range []int{100, 1000, 0} {
+			var dst bytes.Buffer
+			var err error
+			if size == 0 {
+				err = StripICCfromTIFF(&dst, bytes.NewReader(src))
+			} else {
+				err = EmbedICCtoTIFF(&dst, bytes.NewReader(src), testProfile(size, 2), 0)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := testTIFFEntryData(t, dst.Bytes())
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("entry data changed by rewriting a %d byte profile", size)
+			}
+		}
+	}
+}
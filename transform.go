@@ -0,0 +1,566 @@
+//
+// color transform between ICC profiles through the XYZ profile connection space
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"math"
+)
+
+// D50, the PCS illuminant
+var pcsWhite = XYZ{0.9642, 1.0, 0.8249}
+
+// a 3x3 matrix in row-major order
+type matrix3 [9]float64
+
+func (m *matrix3) apply(v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0]*v[0] + m[1]*v[1] + m[2]*v[2],
+		m[3]*v[0] + m[4]*v[1] + m[5]*v[2],
+		m[6]*v[0] + m[7]*v[1] + m[8]*v[2],
+	}
+}
+
+func (m *matrix3) mul(n *matrix3) (r matrix3) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			r[i*3+j] = m[i*3]*n[j] + m[i*3+1]*n[3+j] + m[i*3+2]*n[6+j]
+		}
+	}
+	return
+}
+
+func (m *matrix3) inverse() (inv matrix3, err error) {
+	det := m[0]*(m[4]*m[8]-m[5]*m[7]) - m[1]*(m[3]*m[8]-m[5]*m[6]) + m[2]*(m[3]*m[7]-m[4]*m[6])
+	if math.Abs(det) < 1e-12 {
+		err = fmt.Errorf("singular matrix")
+		return
+	}
+	inv = matrix3{
+		(m[4]*m[8] - m[5]*m[7]) / det, (m[2]*m[7] - m[1]*m[8]) / det, (m[1]*m[5] - m[2]*m[4]) / det,
+		(m[5]*m[6] - m[3]*m[8]) / det, (m[0]*m[8] - m[2]*m[6]) / det, (m[2]*m[3] - m[0]*m[5]) / det,
+		(m[3]*m[7] - m[4]*m[6]) / det, (m[1]*m[6] - m[0]*m[7]) / det, (m[0]*m[4] - m[1]*m[3]) / det,
+	}
+	return
+}
+
+// inverse of a parametric curve
+type inverseParametricCurve ParametricCurve
+
+func (c inverseParametricCurve) Eval(y float64) float64 {
+	y = clamp01(y)
+	p := c.Params
+	pow := func(v, g float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, g)
+	}
+	root := func(v, g float64) float64 {
+		return pow(v, 1/g)
+	}
+	var x float64
+	switch c.Function {
+	case 0:
+		x = root(y, p[0])
+	case 1:
+		x = (root(y, p[0]) - p[2]) / p[1]
+	case 2:
+		x = (root(y-p[3], p[0]) - p[2]) / p[1]
+	case 3:
+		if y >= pow(p[1]*p[4]+p[2], p[0]) {
+			x = (root(y, p[0]) - p[2]) / p[1]
+		} else if p[3] != 0 {
+			x = y / p[3]
+		}
+	case 4:
+		if y >= pow(p[1]*p[4]+p[2], p[0])+p[5] {
+			x = (root(y-p[5], p[0]) - p[2]) / p[1]
+		} else if p[3] != 0 {
+			x = (y - p[6]) / p[3]
+		}
+	}
+	return clamp01(x)
+}
+
+// inverse of a sampled curve; the piecewise linear function is inverted exactly
+type inverseSampledCurve SampledCurve
+
+func (c inverseSampledCurve) Eval(y float64) float64 {
+	t := c
+	n := len(t)
+	if n < 2 {
+		return clamp01(y)
+	}
+	y = clamp01(y)
+	ascending := t[n-1] >= t[0]
+	// find the segment containing y by binary search
+	lo, hi := 0, n-1
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if (t[mid] <= y) == ascending {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	d := t[hi] - t[lo]
+	f := 0.0
+	if d != 0 {
+		f = clamp01((y - t[lo]) / d)
+	} else if (y > t[lo]) == ascending {
+		f = 1
+	}
+	return (float64(lo) + f) / float64(n-1)
+}
+
+// inverse of an arbitrary monotonic curve, computed by bisection
+type inverseCurve struct {
+	c Curve
+}
+
+func (ic inverseCurve) Eval(y float64) float64 {
+	y = clamp01(y)
+	lo, hi := 0.0, 1.0
+	ascending := ic.c.Eval(1) >= ic.c.Eval(0)
+	for i := 0; i < 40; i++ {
+		mid := (lo + hi) / 2
+		if (ic.c.Eval(mid) < y) == ascending {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// invert a curve
+func invertCurve(c Curve) Curve {
+	switch v := c.(type) {
+	case GammaCurve:
+		if v != 0 {
+			return GammaCurve(1 / v)
+		}
+	case ParametricCurve:
+		return inverseParametricCurve(v)
+	case SampledCurve:
+		return inverseSampledCurve(v)
+	}
+	return inverseCurve{c}
+}
+
+// conversion between device values of a profile and the XYZ PCS
+type pcsStage struct {
+	colorSpace string // color space signature
+	channels   int
+	mediaWhite XYZ // media white point, for the absolute colorimetric intent
+	toPCS      func(in []float64) XYZ
+	fromPCS    func(xyz XYZ) []float64
+}
+
+// build a PCS stage of a matrix/TRC profile
+func newMatrixTRCStage(p *Profile) (st *pcsStage, err error) {
+	curves, err := p.ToneCurves()
+	if err != nil {
+		return
+	}
+	if p.Header.ColorSpace == ColorSpaceGray {
+		return newGrayTRCStage(curves[0]), nil
+	}
+	r, g, b, err := p.Colorants()
+	if err != nil {
+		return
+	}
+	return newRGBMatrixStage([3]XYZ{r, g, b}, curves)
+}
+
+// build a PCS stage of a gray TRC
+func newGrayTRCStage(curve Curve) *pcsStage {
+	inverse := invertCurve(curve)
+	// gray values are the luminance of the PCS white
+	return &pcsStage{
+		colorSpace: ColorSpaceGray,
+		channels:   1,
+		mediaWhite: pcsWhite,
+		toPCS: func(in []float64) XYZ {
+			y := curve.Eval(in[0])
+			return XYZ{y * pcsWhite.X, y * pcsWhite.Y, y * pcsWhite.Z}
+		},
+		fromPCS: func(xyz XYZ) []float64 {
+			return []float64{inverse.Eval(xyz.Y)}
+		},
+	}
+}
+
+// build a PCS stage of RGB colorants and tone curves
+func newRGBMatrixStage(colorants [3]XYZ, curves []Curve) (st *pcsStage, err error) {
+	inverse := make([]Curve, len(curves))
+	for i, c := range curves {
+		inverse[i] = invertCurve(c)
+	}
+	r, g, b := colorants[0], colorants[1], colorants[2]
+	m := matrix3{r.X, g.X, b.X, r.Y, g.Y, b.Y, r.Z, g.Z, b.Z}
+	minv, err := m.inverse()
+	if err != nil {
+		return
+	}
+	st = &pcsStage{
+		colorSpace: ColorSpaceRGB,
+		channels:   3,
+		mediaWhite: pcsWhite,
+		toPCS: func(in []float64) XYZ {
+			lin := [3]float64{curves[0].Eval(in[0]), curves[1].Eval(in[1]), curves[2].Eval(in[2])}
+			v := m.apply(lin)
+			return XYZ{v[0], v[1], v[2]}
+		},
+		fromPCS: func(xyz XYZ) []float64 {
+			lin := minv.apply([3]float64{xyz.X, xyz.Y, xyz.Z})
+			return []float64{inverse[0].Eval(lin[0]), inverse[1].Eval(lin[1]), inverse[2].Eval(lin[2])}
+		},
+	}
+	return
+}
+
+// convert CIE Lab to XYZ, relative to the PCS white
+func labToXYZ(l, a, b float64) XYZ {
+	f := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	fy := (l + 16) / 116
+	return XYZ{pcsWhite.X * f(fy+a/500), pcsWhite.Y * f(fy), pcsWhite.Z * f(fy-b/200)}
+}
+
+// convert XYZ to CIE Lab, relative to the PCS white
+func xyzToLab(xyz XYZ) (l, a, b float64) {
+	f := func(t float64) float64 {
+		if t > (6.0/29)*(6.0/29)*(6.0/29) {
+			return math.Cbrt(t)
+		}
+		return t/(3*(6.0/29)*(6.0/29)) + 4.0/29
+	}
+	fx, fy, fz := f(xyz.X/pcsWhite.X), f(xyz.Y/pcsWhite.Y), f(xyz.Z/pcsWhite.Z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// PCS encodings of normalized LUT values
+const (
+	pcsXYZ       = iota // XYZ in u1Fixed15Number
+	pcsLab              // 8-bit or v4 16-bit Lab
+	pcsLabLegacy        // v2 16-bit Lab of lut16Type
+)
+
+// the PCS encoding of a LUT in a profile
+func lutPCSEncoding(p *Profile, lut *Lut) int {
+	switch {
+	case p.Header.PCS == ColorSpaceXYZ:
+		return pcsXYZ
+	case lut.Type == tagTypeLut16:
+		return pcsLabLegacy
+	}
+	return pcsLab
+}
+
+// decode normalized PCS values
+func decodePCS(v []float64, encoding int) XYZ {
+	switch encoding {
+	case pcsLab:
+		return labToXYZ(v[0]*100, v[1]*255-128, v[2]*255-128)
+	case pcsLabLegacy:
+		return labToXYZ(v[0]*65535/65280*100, v[1]*65535/256-128, v[2]*65535/256-128)
+	}
+	const scale = 65535.0 / 32768
+	return XYZ{v[0] * scale, v[1] * scale, v[2] * scale}
+}
+
+// encode XYZ to normalized PCS values
+func encodePCS(xyz XYZ, encoding int) []float64 {
+	switch encoding {
+	case pcsLab:
+		l, a, b := xyzToLab(xyz)
+		return []float64{clamp01(l / 100), clamp01((a + 128) / 255), clamp01((b + 128) / 255)}
+	case pcsLabLegacy:
+		l, a, b := xyzToLab(xyz)
+		return []float64{clamp01(l / 100 * 65280 / 65535), clamp01((a + 128) * 256 / 65535), clamp01((b + 128) * 256 / 65535)}
+	}
+	const scale = 32768 / 65535.0
+	return []float64{clamp01(xyz.X * scale), clamp01(xyz.Y * scale), clamp01(xyz.Z * scale)}
+}
+
+// the first tag present in the profile among signatures
+func (p *Profile) firstTag(signatures ...string) string {
+	for _, sig := range signatures {
+		if p.HasTag(sig) {
+			return sig
+		}
+	}
+	return ""
+}
+
+// LUT tags to use for a rendering intent, in the order of preference
+func lutTagsForIntent(intent RenderingIntent) (aToB, bToA []string) {
+	switch intent {
+	case IntentPerceptual:
+		return []string{TagAToB0}, []string{TagBToA0}
+	case IntentSaturation:
+		return []string{TagAToB2, TagAToB0}, []string{TagBToA2, TagBToA0}
+	}
+	// colorimetric intents
+	return []string{TagAToB1, TagAToB0}, []string{TagBToA1, TagBToA0}
+}
+
+// build a PCS stage of a profile for a rendering intent.
+// LUT-based tags are used if present, and matrix/TRC tags otherwise.
+func newPCSStage(p *Profile, intent RenderingIntent, opts *TransformOptions) (st *pcsStage, err error) {
+	if p.Header.PCS != ColorSpaceXYZ && p.Header.PCS != ColorSpaceLab {
+		err = fmt.Errorf("unsupported PCS %q", p.Header.PCS)
+		return
+	}
+	channels := ColorSpaceChannels(p.Header.ColorSpace)
+	if channels == 0 {
+		err = fmt.Errorf("unsupported color space %q", p.Header.ColorSpace)
+		return
+	}
+	st = &pcsStage{colorSpace: p.Header.ColorSpace, channels: channels}
+
+	// matrix/TRC
+	if (p.Header.ColorSpace == ColorSpaceRGB && p.HasTag(TagRedColorant)) ||
+		(p.Header.ColorSpace == ColorSpaceGray && p.HasTag(TagGrayTRC)) {
+		if p.Header.PCS != ColorSpaceXYZ {
+			err = fmt.Errorf("matrix/TRC profile must have XYZ PCS")
+			return
+		}
+		st, err = newMatrixTRCStage(p)
+		if err != nil {
+			return
+		}
+	}
+
+	// LUT-based tags override matrix/TRC
+	aToB, bToA := lutTagsForIntent(intent)
+	if sig := p.firstTag(aToB...); sig != "" {
+		var lut *Lut
+		lut, err = p.TagLut(sig)
+		if err != nil {
+			return
+		}
+		if lut.InputChannels != channels || lut.OutputChannels != 3 {
+			err = fmt.Errorf("tag %q has wrong number of channels", sig)
+			return
+		}
+		encoding := lutPCSEncoding(p, lut)
+		st.toPCS = func(in []float64) XYZ {
+			return decodePCS(lut.EvalWith(in, opts.Interpolation), encoding)
+		}
+	}
+	if sig := p.firstTag(bToA...); sig != "" {
+		var lut *Lut
+		lut, err = p.TagLut(sig)
+		if err != nil {
+			return
+		}
+		if lut.InputChannels != 3 || lut.OutputChannels != channels {
+			err = fmt.Errorf("tag %q has wrong number of channels", sig)
+			return
+		}
+		encoding := lutPCSEncoding(p, lut)
+		st.fromPCS = func(xyz XYZ) []float64 {
+			return lut.EvalWith(encodePCS(xyz, encoding), opts.Interpolation)
+		}
+	}
+
+	st.mediaWhite = pcsWhite
+	if p.HasTag(TagMediaWhitePoint) {
+		st.mediaWhite, err = p.MediaWhitePoint()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// TransformOptions are options of a color transform.
+type TransformOptions struct {
+	// rendering intent; ignored if DefaultIntent is set
+	Intent RenderingIntent
+	// use the default rendering intent in the header of the source profile
+	DefaultIntent bool
+	// interpolation method of LUT-based profiles
+	Interpolation Interpolation
+	// scale the source black point to the destination black point;
+	// ignored for the absolute colorimetric intent
+	BlackPointCompensation bool
+}
+
+// Transform converts color values from a source profile to a destination profile.
+type Transform struct {
+	src, dst *pcsStage
+	intent   RenderingIntent
+
+	// media white scaling of the absolute colorimetric intent
+	srcWhiteScale, dstWhiteScale XYZ
+
+	// black point compensation; scale and offset of each XYZ component
+	bpc                 bool
+	bpcScale, bpcOffset XYZ
+}
+
+// Create a transform between two profiles,
+// using the default rendering intent in the header of the source profile.
+func NewTransform(src, dst *Profile) (t *Transform, err error) {
+	return NewTransformWithOptions(src, dst, &TransformOptions{DefaultIntent: true})
+}
+
+// Create a transform between two profiles with options.
+// If opts is nil then the default rendering intent in the header of the source profile is used.
+//
+// LUT-based tags are chosen by the rendering intent following the ICC fallback rules:
+// a missing AToB1/AToB2 or BToA1/BToA2 tag falls back to AToB0 or BToA0,
+// and a profile without LUT-based tags uses its matrix/TRC tags for all intents.
+// The absolute colorimetric intent uses the colorimetric tags and rescales the PCS values to the media white points.
+func NewTransformWithOptions(src, dst *Profile, opts *TransformOptions) (t *Transform, err error) {
+	opts, intent, err := resolveIntent(src, opts)
+	if err != nil {
+		return
+	}
+
+	s, err := newPCSStage(src, intent, opts)
+	if err != nil {
+		return
+	}
+	d, err := newPCSStage(dst, intent, opts)
+	if err != nil {
+		return
+	}
+	return newTransform(s, d, intent, opts)
+}
+
+// resolve the rendering intent of a transform from the source profile and options;
+// nil options select the default intent of the source profile
+func resolveIntent(src *Profile, opts *TransformOptions) (*TransformOptions, RenderingIntent, error) {
+	if opts == nil {
+		opts = &TransformOptions{DefaultIntent: true}
+	}
+	intent := opts.Intent
+	if opts.DefaultIntent {
+		intent = src.Header.RenderingIntent
+	}
+	if intent < IntentPerceptual || intent > IntentAbsoluteColorimetric {
+		return nil, 0, fmt.Errorf("unknown rendering intent %d", int(intent))
+	}
+	return opts, intent, nil
+}
+
+// create a transform between two PCS stages
+func newTransform(s, d *pcsStage, intent RenderingIntent, opts *TransformOptions) (t *Transform, err error) {
+	if s.toPCS == nil {
+		err = fmt.Errorf("source profile has no device to PCS transform")
+		return
+	}
+	if d.fromPCS == nil {
+		err = fmt.Errorf("destination profile has no PCS to device transform")
+		return
+	}
+	t = &Transform{src: s, dst: d, intent: intent}
+
+	if intent == IntentAbsoluteColorimetric {
+		sw, dw := s.mediaWhite, d.mediaWhite
+		if dw.X == 0 || dw.Y == 0 || dw.Z == 0 {
+			err = fmt.Errorf("invalid media white point")
+			return nil, err
+		}
+		t.srcWhiteScale = XYZ{sw.X / pcsWhite.X, sw.Y / pcsWhite.Y, sw.Z / pcsWhite.Z}
+		t.dstWhiteScale = XYZ{pcsWhite.X / dw.X, pcsWhite.Y / dw.Y, pcsWhite.Z / dw.Z}
+	} else if opts.BlackPointCompensation {
+		t.setBlackPoints(detectBlackPoint(s), detectBlackPoint(d))
+	}
+	return
+}
+
+// find the black point of a profile, in relative colorimetric PCS.
+// The darkest device color is mapped to the PCS, and only its luminance is kept.
+func detectBlackPoint(st *pcsStage) XYZ {
+	var black XYZ
+	if st.toPCS == nil {
+		return black
+	}
+	if st.fromPCS != nil {
+		// the darkest color the device can reproduce
+		black = st.toPCS(st.fromPCS(XYZ{}))
+	} else {
+		// device black; full ink for subtractive color spaces
+		dev := make([]float64, st.channels)
+		switch st.colorSpace {
+		case ColorSpaceCMYK, ColorSpaceCMY:
+			for i := range dev {
+				dev[i] = 1
+			}
+		}
+		black = st.toPCS(dev)
+	}
+
+	// keep the luminance only; a black point lighter than the mid gray is bogus
+	y := black.Y
+	if y < 0 || y > 0.5 {
+		y = 0
+	}
+	return XYZ{y * pcsWhite.X, y * pcsWhite.Y, y * pcsWhite.Z}
+}
+
+// set up the black point compensation.
+// The source black point is mapped to the destination black point, keeping the PCS white.
+func (t *Transform) setBlackPoints(srcBlack, dstBlack XYZ) {
+	if srcBlack == dstBlack {
+		return
+	}
+	scale := func(w, sb, db float64) (s, o float64) {
+		s = (w - db) / (w - sb)
+		return s, db - sb*s
+	}
+	t.bpc = true
+	t.bpcScale.X, t.bpcOffset.X = scale(pcsWhite.X, srcBlack.X, dstBlack.X)
+	t.bpcScale.Y, t.bpcOffset.Y = scale(pcsWhite.Y, srcBlack.Y, dstBlack.Y)
+	t.bpcScale.Z, t.bpcOffset.Z = scale(pcsWhite.Z, srcBlack.Z, dstBlack.Z)
+}
+
+// Intent returns the rendering intent of the transform.
+func (t *Transform) Intent() RenderingIntent {
+	return t.intent
+}
+
+// InputChannels returns the number of channels of the source color space.
+func (t *Transform) InputChannels() int {
+	return t.src.channels
+}
+
+// OutputChannels returns the number of channels of the destination color space.
+func (t *Transform) OutputChannels() int {
+	return t.dst.channels
+}
+
+// Convert converts a color. Values are normalized to [0, 1].
+// in must have InputChannels() values; Convert panics otherwise.
+func (t *Transform) Convert(in []float64) []float64 {
+	if len(in) != t.src.channels {
+		panic(fmt.Sprintf("imageicc: Transform.Convert: %d input values for %d channels", len(in), t.src.channels))
+	}
+	xyz := t.src.toPCS(in)
+	if t.intent == IntentAbsoluteColorimetric {
+		xyz = XYZ{
+			xyz.X * t.srcWhiteScale.X * t.dstWhiteScale.X,
+			xyz.Y * t.srcWhiteScale.Y * t.dstWhiteScale.Y,
+			xyz.Z * t.srcWhiteScale.Z * t.dstWhiteScale.Z,
+		}
+	} else if t.bpc {
+		xyz = XYZ{
+			xyz.X*t.bpcScale.X + t.bpcOffset.X,
+			xyz.Y*t.bpcScale.Y + t.bpcOffset.Y,
+			xyz.Z*t.bpcScale.Z + t.bpcOffset.Z,
+		}
+	}
+	return t.dst.fromPCS(xyz)
+}
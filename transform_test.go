@@ -0,0 +1,309 @@
+package imageicc
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// D50-adapted colorants of sRGB and Display P3
+var (
+	testSRGBColorants = [3]XYZ{{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}}
+	testP3Colorants   = [3]XYZ{{0.5151, 0.2412, -0.0011}, {0.2920, 0.6922, 0.0419}, {0.1571, 0.0666, 0.7841}}
+)
+
+// a matrix/TRC RGB profile
+func testRGBProfile(t *testing.T, colorants [3]XYZ, trc []byte) *Profile {
+	c := colorants
+	p, err := ParseProfile(testProfileWithTags([]testTag{
+		{"wtpt", testXYZTag(0.9642, 1, 0.8249)},
+		{"rXYZ", testXYZTag(c[0].X, c[0].Y, c[0].Z)},
+		{"gXYZ", testXYZTag(c[1].X, c[1].Y, c[1].Z)},
+		{"bXYZ", testXYZTag(c[2].X, c[2].Y, c[2].Z)},
+		{"rTRC", trc},
+		{"gTRC", nil},
+		{"bTRC", nil},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// a gray profile with a TRC
+func testGrayProfile(t *testing.T, trc []byte) *Profile {
+	b := testProfileWithTags([]testTag{
+		{"wtpt", testXYZTag(0.9642, 1, 0.8249)},
+		{"kTRC", trc},
+	})
+	copy(b[16:], ColorSpaceGray)
+	p, err := ParseProfile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// compare color values with a tolerance
+func nearColor(a, b []float64, tolerance float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMatrixTRCTransform(t *testing.T) {
+	srgb := testRGBProfile(t, testSRGBColorants, testParaTag(3, testSRGBParams...))
+	p3 := testRGBProfile(t, testP3Colorants, testParaTag(3, testSRGBParams...))
+	gamma22 := testRGBProfile(t, testSRGBColorants, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01\x02\x33"))
+	sampled := testRGBProfile(t, testSRGBColorants, []byte("curv\x00\x00\x00\x00\x00\x00\x00\x03\x00\x00\x40\x00\xff\xff"))
+	gray := testGrayProfile(t, testParaTag(3, testSRGBParams...))
+
+	// identity
+	tr, err := NewTransform(srgb, srgb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range [][]float64{{0, 0, 0}, {1, 1, 1}, {0.2, 0.5, 0.8}, {0.01, 0.02, 0.03}} {
+		if out := tr.Convert(c); !nearColor(out, c, 1e-6) {
+			t.Errorf("identity transform mismatch: %v -> %v", c, out)
+		}
+	}
+
+	// P3 red is out of the sRGB gamut
+	tr, err = NewTransform(p3, srgb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := tr.Convert([]float64{1, 0, 0}); !nearColor(out, []float64{1, 0, 0}, 1e-6) {
+		t.Errorf("P3 red mismatch: %v", out)
+	}
+	// white and gray stay neutral
+	if out := tr.Convert([]float64{0.5, 0.5, 0.5}); !nearColor(out, []float64{0.5, 0.5, 0.5}, 1e-3) {
+		t.Errorf("P3 gray mismatch: %v", out)
+	}
+	// round trip of an in-gamut color
+	back, err := NewTransform(srgb, p3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := []float64{0.6, 0.4, 0.3}
+	if out := tr.Convert(back.Convert(c)); !nearColor(out, c, 1e-6) {
+		t.Errorf("round trip mismatch: %v -> %v", c, out)
+	}
+	if in := back.Convert(c); in[0] >= c[0] {
+		t.Errorf("sRGB in P3 should be less saturated: %v", in)
+	}
+
+	// wrong number of input values
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("short input accepted")
+			}
+		}()
+		tr.Convert([]float64{0.5, 0.5})
+	}()
+
+	// curve inversion of gamma and sampled curves
+	for _, p := range []*Profile{gamma22, sampled} {
+		tr, err = NewTransform(srgb, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err = NewTransform(p, srgb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := back.Convert(tr.Convert(c)); !nearColor(out, c, 1e-6) {
+			t.Errorf("round trip mismatch: %v -> %v", c, out)
+		}
+	}
+
+	// gray to RGB
+	tr, err = NewTransform(gray, srgb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.InputChannels() != 1 || tr.OutputChannels() != 3 {
+		t.Errorf("channel count mismatch")
+	}
+	if out := tr.Convert([]float64{0.3}); !nearColor(out, []float64{0.3, 0.3, 0.3}, 1e-3) {
+		t.Errorf("gray to RGB mismatch: %v", out)
+	}
+	tr, err = NewTransform(srgb, gray)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := tr.Convert([]float64{0.7, 0.7, 0.7}); !nearColor(out, []float64{0.7}, 1e-3) {
+		t.Errorf("RGB to gray mismatch: %v", out)
+	}
+}
+
+// a CMYK to Lab lutAToBType mapping ink coverage to neutral lightness, from paperL to inkL
+func testCMYKLut(paperL, inkL float64) []byte {
+	be := binary.BigEndian
+	curv := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")
+	mab := make([]byte, 32)
+	copy(mab, "mAB ")
+	mab[8], mab[9] = 4, 3
+	be.PutUint32(mab[12:], uint32(len(mab))) // B curves
+	for i := 0; i < 3; i++ {
+		mab = append(mab, curv...)
+	}
+	be.PutUint32(mab[24:], uint32(len(mab))) // CLUT
+	clutHeader := make([]byte, 20)
+	clutHeader[0], clutHeader[1], clutHeader[2], clutHeader[3], clutHeader[16] = 2, 2, 2, 2, 2
+	mab = append(mab, clutHeader...)
+	for i := 0; i < 16; i++ {
+		ink := 0
+		for j := 0; j < 4; j++ {
+			ink += (i >> j) & 1
+		}
+		l := uint16(math.Round((inkL + (paperL-inkL)*(1-float64(ink)/4)) / 100 * 65535))
+		a := uint16(math.Round(128.0 / 255 * 65535))
+		mab = append(mab, byte(l>>8), byte(l), byte(a>>8), byte(a), byte(a>>8), byte(a))
+	}
+	be.PutUint32(mab[28:], uint32(len(mab))) // A curves
+	for i := 0; i < 4; i++ {
+		mab = append(mab, curv...)
+	}
+	return mab
+}
+
+// a CMYK output profile
+func testCMYKProfile(t *testing.T, tags ...testTag) *Profile {
+	if len(tags) == 0 {
+		tags = []testTag{
+			{"wtpt", testXYZTag(0.9642, 1, 0.8249)},
+			{"A2B0", testCMYKLut(100, 0)},
+		}
+	}
+	b := testProfileWithTags(tags)
+	copy(b[12:], ClassOutput+ColorSpaceCMYK+ColorSpaceLab)
+	p, err := ParseProfile(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLutTransform(t *testing.T) {
+	cmyk := testCMYKProfile(t)
+	srgb := testRGBProfile(t, testSRGBColorants, testParaTag(3, testSRGBParams...))
+
+	for _, ip := range []Interpolation{InterpolationTrilinear, InterpolationTetrahedral} {
+		tr, err := NewTransformWithOptions(cmyk, srgb, &TransformOptions{Interpolation: ip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.InputChannels() != 4 || tr.OutputChannels() != 3 {
+			t.Fatalf("channel count mismatch")
+		}
+		if out := tr.Convert([]float64{0, 0, 0, 0}); !nearColor(out, []float64{1, 1, 1}, 1e-3) {
+			t.Errorf("%v: paper white mismatch: %v", ip, out)
+		}
+		if out := tr.Convert([]float64{1, 1, 1, 1}); !nearColor(out, []float64{0, 0, 0}, 1e-3) {
+			t.Errorf("%v: black mismatch: %v", ip, out)
+		}
+		// L* 50 is sRGB 0.4663
+		if out := tr.Convert([]float64{0.3, 0.7, 0.6, 0.4}); !nearColor(out, []float64{0.4663, 0.4663, 0.4663}, 1e-3) {
+			t.Errorf("%v: mid gray mismatch: %v", ip, out)
+		}
+	}
+
+	// the CMYK profile has no BToA tag
+	if _, err := NewTransform(srgb, cmyk); err == nil {
+		t.Errorf("missing BToA tag not detected")
+	}
+}
+
+func TestRenderingIntent(t *testing.T) {
+	srgb := testRGBProfile(t, testSRGBColorants, testParaTag(3, testSRGBParams...))
+
+	// perceptual and colorimetric tables differ in the paper lightness
+	wtpt := labToXYZ(90, 0, 0)
+	cmyk := testCMYKProfile(t,
+		testTag{"wtpt", testXYZTag(wtpt.X, wtpt.Y, wtpt.Z)},
+		testTag{"A2B0", testCMYKLut(100, 0)},
+		testTag{"A2B1", testCMYKLut(50, 0)},
+	)
+	paper := []float64{0, 0, 0, 0}
+	for _, tc := range []struct {
+		intent RenderingIntent
+		gray   float64 // sRGB gray level of the paper
+	}{
+		{IntentPerceptual, 1},
+		{IntentRelativeColorimetric, 0.4663}, // L* 50
+		{IntentSaturation, 1},                // falls back to A2B0
+		{IntentAbsoluteColorimetric, 0.4108}, // L* 50 scaled by the media white of L* 90
+	} {
+		tr, err := NewTransformWithOptions(cmyk, srgb, &TransformOptions{Intent: tc.intent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.Intent() != tc.intent {
+			t.Errorf("intent mismatch")
+		}
+		if out := tr.Convert(paper); !nearColor(out, []float64{tc.gray, tc.gray, tc.gray}, 1e-3) {
+			t.Errorf("%v: paper white mismatch: %v", tc.intent, out)
+		}
+	}
+
+	// the default intent in the header; relative colorimetric for the test profiles
+	tr, err := NewTransform(cmyk, srgb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Intent() != IntentRelativeColorimetric {
+		t.Errorf("default intent not used: %v", tr.Intent())
+	}
+	// nil options mean the default intent as well
+	tr, err = NewTransformWithOptions(cmyk, srgb, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Intent() != IntentRelativeColorimetric {
+		t.Errorf("default intent not used with nil options: %v", tr.Intent())
+	}
+	if _, err := NewTransformWithOptions(cmyk, srgb, &TransformOptions{Intent: 4}); err == nil {
+		t.Errorf("invalid intent not detected")
+	}
+}
+
+func TestBlackPointCompensation(t *testing.T) {
+	srgb := testRGBProfile(t, testSRGBColorants, testParaTag(3, testSRGBParams...))
+	// a press profile whose darkest black is L* 20
+	cmyk := testCMYKProfile(t,
+		testTag{"wtpt", testXYZTag(0.9642, 1, 0.8249)},
+		testTag{"A2B0", testCMYKLut(100, 20)},
+	)
+
+	for _, tc := range []struct {
+		bpc   bool
+		black float64 // sRGB gray level of the max ink
+		mid   float64 // sRGB gray level of L* 60
+	}{
+		{false, 0.1897, 0.5672},
+		{true, 0, 0.5459},
+	} {
+		tr, err := NewTransformWithOptions(cmyk, srgb, &TransformOptions{BlackPointCompensation: tc.bpc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := tr.Convert([]float64{1, 1, 1, 1}); !nearColor(out, []float64{tc.black, tc.black, tc.black}, 1e-3) {
+			t.Errorf("bpc %v: black mismatch: %v", tc.bpc, out)
+		}
+		if out := tr.Convert([]float64{0.5, 0.5, 0.5, 0.5}); !nearColor(out, []float64{tc.mid, tc.mid, tc.mid}, 1e-3) {
+			t.Errorf("bpc %v: mid tone mismatch: %v", tc.bpc, out)
+		}
+		if out := tr.Convert([]float64{0, 0, 0, 0}); !nearColor(out, []float64{1, 1, 1}, 1e-3) {
+			t.Errorf("bpc %v: paper white mismatch: %v", tc.bpc, out)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+//
+// read and write embedded ICC profile in a WebP file
+//
+// WebP container spec
+// https://developers.google.com/speed/webp/docs/riff_container
+//
+
+package imageicc
+
+import (
+	"fmt"
+	"io"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// VP8X feature flags
+const (
+	webpFlagAlpha = 0x10
+	webpFlagICC   = 0x20
+)
+
+// size of the VP8X chunk data
+const webpVP8XSize = 10
+
+// the RIFF header of a WebP file
+type webpHeader struct {
+	RIFF string `binary:"[4]byte"` // "RIFF"
+	Size int    `binary:"uint32"`  // file size - 8
+	WEBP string `binary:"[4]byte"` // "WEBP"
+}
+
+// a RIFF chunk. {FourCC, Size, [DATA], padding to even size}
+// Value is stored in little-endian.
+type webpChunk struct {
+	FourCC     string `binary:"[4]byte"`
+	Size       int    `binary:"uint32"` // size of actual data, without padding
+	DataOffset int64  `binary:"ignore"` // Offset of actual data in the file stream
+}
+
+// size of the chunk in the file, including the header and padding
+func (ch webpChunk) fileSize() int64 {
+	return 8 + int64(ch.Size) + int64(ch.Size&1)
+}
+
+// Parse WebP and get FourCC, offset and size of chunks
+func parseWebP(in io.ReadSeeker) (chunks []webpChunk, err error) {
+	var h webpHeader
+	_, err = bst.Read(in, bst.LittleEndian, &h)
+	if err != nil {
+		return
+	}
+	if h.RIFF != "RIFF" || h.WEBP != "WEBP" {
+		err = fmt.Errorf("invalid WebP header")
+		return
+	}
+	offset := int64(12)
+	end := int64(h.Size) + 8
+	for offset < end {
+		var ch webpChunk
+		_, err = bst.Read(in, bst.LittleEndian, &ch)
+		if err == io.EOF { // tolerate a RIFF size larger than the file
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+		ch.DataOffset = offset + 8
+		chunks = append(chunks, ch)
+		offset, err = in.Seek(ch.DataOffset+ch.fileSize()-8, io.SeekStart)
+		if err != nil {
+			return
+		}
+	}
+	if len(chunks) == 0 {
+		err = fmt.Errorf("no chunk in WebP")
+		return
+	}
+	return
+}
+
+// read the data of a chunk
+func readWebPChunk(in io.ReadSeeker, ch webpChunk) (data []byte, err error) {
+	_, err = in.Seek(ch.DataOffset, io.SeekStart)
+	if err != nil {
+		return
+	}
+	data = make([]byte, ch.Size)
+	_, err = io.ReadFull(in, data)
+	return
+}
+
+// Read ICC profile embedded in a WebP file.
+// If there is no ICC profile then nil data and no error is returned.
+func LoadICCfromWebP(in io.ReadSeeker) (iccProfile []byte, err error) {
+	chunks, err := parseWebP(in)
+	if err != nil {
+		return
+	}
+	if chunks[0].FourCC != "VP8X" {
+		// simple format; no ICC profile
+		return
+	}
+	vp8x, err := readWebPChunk(in, chunks[0])
+	if err != nil {
+		return
+	}
+	if len(vp8x) < webpVP8XSize {
+		err = fmt.Errorf("VP8X chunk too short")
+		return
+	}
+	if vp8x[0]&webpFlagICC == 0 {
+		return
+	}
+	for _, ch := range chunks[1:] {
+		if ch.FourCC == "ICCP" {
+			return readWebPChunk(in, ch)
+		}
+	}
+	err = fmt.Errorf("ICCP chunk not found")
+	return
+}
+
+// build VP8X chunk data of a WebP in the simple format, from the dimension of the bitstream
+func makeWebPVP8X(in io.ReadSeeker, ch webpChunk) (vp8x []byte, err error) {
+	head := ch
+	if head.Size > 10 {
+		head.Size = 10
+	}
+	data, err := readWebPChunk(in, head)
+	if err != nil {
+		return
+	}
+	var width, height int
+	var flags byte
+	switch ch.FourCC {
+	case "VP8 ": // lossy
+		// 3-byte frame tag, start code 0x9d 0x01 0x2a, then 14-bit width and height
+		if len(data) < 10 || data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+			err = fmt.Errorf("invalid VP8 bitstream")
+			return
+		}
+		width = int(bst.LittleEndian.Uint16(data[6:])) & 0x3fff
+		height = int(bst.LittleEndian.Uint16(data[8:])) & 0x3fff
+	case "VP8L": // lossless
+		// signature 0x2f, then 14-bit width-1, 14-bit height-1 and the alpha flag
+		if len(data) < 5 || data[0] != 0x2f {
+			err = fmt.Errorf("invalid VP8L bitstream")
+			return
+		}
+		v := bst.LittleEndian.Uint32(data[1:])
+		width = int(v&0x3fff) + 1
+		height = int(v>>14&0x3fff) + 1
+		if v>>28&1 != 0 {
+			flags |= webpFlagAlpha
+		}
+	default:
+		err = fmt.Errorf("unknown WebP bitstream %q", ch.FourCC)
+		return
+	}
+	vp8x = make([]byte, webpVP8XSize)
+	vp8x[0] = flags
+	// canvas width-1 and height-1 in 24 bits
+	w, h := width-1, height-1
+	vp8x[4], vp8x[5], vp8x[6] = byte(w), byte(w>>8), byte(w>>16)
+	vp8x[7], vp8x[8], vp8x[9] = byte(h), byte(h>>8), byte(h>>16)
+	return
+}
+
+// write a RIFF chunk with padding
+func writeWebPChunk(w io.Writer, fourCC string, data []byte) (err error) {
+	ch := webpChunk{FourCC: fourCC, Size: len(data)}
+	_, err = bst.Write(w, bst.LittleEndian, &ch)
+	if err != nil {
+		return
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return
+	}
+	if len(data)&1 != 0 {
+		_, err = w.Write([]byte{0})
+	}
+	return
+}
+
+// copy a WebP stream, removing existing ICCP chunks
+// and inserting a new ICCP chunk if iccProfile is not nil.
+// The ICC flag of VP8X and the RIFF size are updated; a VP8X chunk is added to a simple format file if needed.
+func rewriteWebPICC(out io.Writer, in io.ReadSeeker, iccProfile []byte) (err error) {
+	chunks, err := parseWebP(in)
+	if err != nil {
+		return
+	}
+
+	// the VP8X chunk, which must be the first chunk of the extended format
+	var vp8x []byte
+	rest := chunks
+	if chunks[0].FourCC == "VP8X" {
+		vp8x, err = readWebPChunk(in, chunks[0])
+		if err != nil {
+			return
+		}
+		if len(vp8x) < webpVP8XSize {
+			err = fmt.Errorf("VP8X chunk too short")
+			return
+		}
+		rest = chunks[1:]
+	} else if iccProfile != nil {
+		vp8x, err = makeWebPVP8X(in, chunks[0])
+		if err != nil {
+			return
+		}
+	}
+	if vp8x != nil {
+		if iccProfile != nil {
+			vp8x[0] |= webpFlagICC
+		} else {
+			vp8x[0] &^= webpFlagICC
+		}
+	}
+
+	// compute the RIFF size
+	size := int64(4) // "WEBP"
+	if vp8x != nil {
+		size += webpChunk{Size: len(vp8x)}.fileSize()
+	}
+	if iccProfile != nil {
+		size += webpChunk{Size: len(iccProfile)}.fileSize()
+	}
+	for _, ch := range rest {
+		if ch.FourCC != "ICCP" {
+			size += ch.fileSize()
+		}
+	}
+	if size > 0xffffffff-8 {
+		err = fmt.Errorf("WebP too large")
+		return
+	}
+
+	_, err = bst.Write(out, bst.LittleEndian, &webpHeader{RIFF: "RIFF", Size: int(size), WEBP: "WEBP"})
+	if err != nil {
+		return
+	}
+	if vp8x != nil {
+		err = writeWebPChunk(out, "VP8X", vp8x)
+		if err != nil {
+			return
+		}
+	}
+	// ICCP must follow VP8X
+	if iccProfile != nil {
+		err = writeWebPChunk(out, "ICCP", iccProfile)
+		if err != nil {
+			return
+		}
+	}
+	for _, ch := range rest {
+		if ch.FourCC == "ICCP" {
+			continue
+		}
+		_, err = in.Seek(ch.DataOffset-8, io.SeekStart)
+		if err != nil {
+			return
+		}
+		_, err = io.CopyN(out, in, ch.fileSize())
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Copy a WebP file from in to out, embedding an ICC profile.
+// Any ICCP chunk already in the file is removed.
+// A file in the simple format is converted to the extended format, with a VP8X chunk.
+func EmbedICCtoWebP(out io.Writer, in io.ReadSeeker, iccProfile []byte) (err error) {
+	if len(iccProfile) == 0 {
+		err = fmt.Errorf("empty icc profile")
+		return
+	}
+	return rewriteWebPICC(out, in, iccProfile)
+}
+
+// Copy a WebP file from in to out, removing the embedded ICC profile.
+func StripICCfromWebP(out io.Writer, in io.ReadSeeker) (err error) {
+	return rewriteWebPICC(out, in, nil)
+}
@@ -0,0 +1,136 @@
+package imageicc
+
+import (
+	"bytes"
+	"testing"
+
+	bst "github.com/mixcode/binarystruct"
+)
+
+// a WebP file of a fake bitstream in the simple format
+func testWebP(lossy bool) []byte {
+	var bitstream []byte
+	fourCC := "VP8L"
+	if lossy {
+		// key frame tag, start code, 16x8
+		fourCC = "VP8 "
+		bitstream = []byte{0x50, 0x01, 0x00, 0x9d, 0x01, 0x2a, 16, 0, 8, 0, 1, 2, 3}
+	} else {
+		// signature, 15 and 7 in 14 bits each, alpha flag
+		v := uint32(15) | 7<<14 | 1<<28
+		bitstream = []byte{0x2f, byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), 1, 2}
+	}
+	le := bst.LittleEndian
+	b := []byte("RIFF\x00\x00\x00\x00WEBP")
+	b = append(b, fourCC...)
+	b = append(b, 0, 0, 0, 0)
+	le.PutUint32(b[len(b)-4:], uint32(len(bitstream)))
+	b = append(b, bitstream...)
+	if len(bitstream)&1 != 0 {
+		b = append(b, 0)
+	}
+	// an EXIF chunk of odd size
+	b = append(b, "EXIF\x03\x00\x00\x00abc\x00"...)
+	le.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func TestEmbedICCtoWebP(t *testing.T) {
+	icc := testProfile(2001, 1)
+	for _, lossy := range []bool{true, false} {
+		src := testWebP(lossy)
+		if loaded, err := LoadICCfromWebP(bytes.NewReader(src)); err != nil || loaded != nil {
+			t.Fatalf("profile in the source: %v", err)
+		}
+
+		var dst bytes.Buffer
+		err := EmbedICCtoWebP(&dst, bytes.NewReader(src), icc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := dst.Bytes()
+		le := bst.LittleEndian
+		if int(le.Uint32(b[4:])) != len(b)-8 {
+			t.Errorf("RIFF size %d, file size %d", le.Uint32(b[4:]), len(b))
+		}
+		if string(b[12:16]) != "VP8X" || string(b[30:34]) != "ICCP" {
+			t.Fatalf("VP8X and ICCP not at the start: %q", b[12:34])
+		}
+		vp8x := b[20:30]
+		wantFlags := byte(webpFlagICC)
+		if !lossy {
+			wantFlags |= webpFlagAlpha
+		}
+		if vp8x[0] != wantFlags || vp8x[4] != 15 || vp8x[7] != 7 {
+			t.Errorf("VP8X % x", vp8x)
+		}
+		loaded, err := LoadICCfromWebP(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, icc) {
+			t.Errorf("loaded profile differs")
+		}
+
+		// replace the profile
+		icc2 := testProfile(1000, 2)
+		var dst2 bytes.Buffer
+		err = EmbedICCtoWebP(&dst2, bytes.NewReader(b), icc2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err = LoadICCfromWebP(bytes.NewReader(dst2.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded, icc2) || dst2.Len() != len(b)-len(icc)-1+len(icc2) {
+			t.Errorf("profile not replaced")
+		}
+
+		// strip; the VP8X chunk remains without the ICC flag
+		var stripped bytes.Buffer
+		err = StripICCfromWebP(&stripped, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := stripped.Bytes()
+		if sb[20]&webpFlagICC != 0 || int(le.Uint32(sb[4:])) != len(sb)-8 || bytes.Contains(sb, []byte("ICCP")) {
+			t.Errorf("profile not removed")
+		}
+		if !bytes.HasSuffix(sb, src[12:]) {
+			t.Errorf("chunks not preserved")
+		}
+	}
+}
+
+func TestLibwebpSample(t *testing.T) {
+	// simple format files written by libwebp, without a profile
+	icc := testSample(t, "display-p3-v2.icc")
+	for _, name := range []string{"libwebp-lossy.webp", "libwebp-lossless.webp"} {
+		src := testSample(t, name)
+		var dst bytes.Buffer
+		err := EmbedICCtoWebP(&dst, bytes.NewReader(src), icc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := dst.Bytes()
+		if vp8x := b[20:30]; vp8x[0] != webpFlagICC || vp8x[4] != 15 || vp8x[7] != 7 {
+			t.Errorf("%s: VP8X % x", name, vp8x)
+		}
+		if loaded, err := LoadICCfromWebP(bytes.NewReader(b)); err != nil || !bytes.Equal(loaded, icc) {
+			t.Errorf("%s: embedded profile mismatch: %v", name, err)
+		}
+		if !bytes.HasSuffix(b, src[12:]) {
+			t.Errorf("%s: bitstream not preserved", name)
+		}
+
+		var stripped bytes.Buffer
+		err = StripICCfromWebP(&stripped, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded, err := LoadICCfromWebP(bytes.NewReader(stripped.Bytes())); err != nil || loaded != nil || !bytes.HasSuffix(stripped.Bytes(), src[12:]) {
+			t.Errorf("%s: profile not removed: %v", name, err)
+		}
+	}
+}